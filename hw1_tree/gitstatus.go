@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// gitStatus is the state of an entry in the working tree of a git repository
+type gitStatus struct {
+	staged    bool
+	modified  bool
+	untracked bool
+	ignored   bool
+	changes   bool // a directory containing changed files
+}
+
+func (s gitStatus) String() string {
+	var states []string
+	if s.staged {
+		states = append(states, "staged")
+	}
+	if s.modified {
+		states = append(states, "modified")
+	}
+	if s.untracked {
+		states = append(states, "untracked")
+	}
+	if s.ignored {
+		states = append(states, "ignored")
+	}
+	if s.changes {
+		states = append(states, "contains changes")
+	}
+	return strings.Join(states, ", ")
+}
+
+// annotateGit marks the entries under root with their state in the enclosing git repository
+func annotateGit(nodes []*node, root string) error {
+
+	// the paths in the status are relative to the top of the repository
+	prefix, err := git(root, "rev-parse", "--show-prefix")
+	if err != nil {
+		return err
+	}
+	prefix = strings.TrimSpace(prefix)
+
+	output, err := git(root, "status", "--porcelain=v1", "-z", "--ignored", "--untracked-files=normal", "--", ".")
+	if err != nil {
+		return err
+	}
+
+	statuses := parseGitStatus(output)
+	markGit(nodes, root, prefix, statuses)
+	return nil
+}
+
+// skipGitDirs removes the repository directories, they are not part of the working tree
+func skipGitDirs(nodes []*node) []*node {
+
+	kept := nodes[:0]
+
+	for _, n := range nodes {
+		if n.isDir && n.name == ".git" {
+			continue
+		}
+		n.children = skipGitDirs(n.children)
+		kept = append(kept, n)
+	}
+
+	return kept
+}
+
+// git runs a git command in dir, only the local repository is read
+func git(dir string, args ...string) (string, error) {
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+
+	cmd := exec.Command("git", append([]string{"-C", dir}, args...)...)
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+
+	// the status must not be affected by the settings of the caller
+	cmd.Env = append(os.Environ(), "GIT_OPTIONAL_LOCKS=0", "LC_ALL=C")
+
+	if err := cmd.Run(); err != nil {
+		var exitErr *exec.ExitError
+		if errors.As(err, &exitErr) {
+			return "", fmt.Errorf("git %s: %s", args[0], strings.TrimSpace(stderr.String()))
+		}
+		return "", err
+	}
+
+	return stdout.String(), nil
+}
+
+// parseGitStatus reads `git status --porcelain=v1 -z` output into states by slash separated paths,
+// a path ending with a slash stands for the whole directory
+func parseGitStatus(output string) map[string]gitStatus {
+
+	statuses := make(map[string]gitStatus)
+	records := strings.Split(output, "\x00")
+
+	for i := 0; i < len(records); i++ {
+		record := records[i]
+		if len(record) < 4 {
+			continue
+		}
+
+		x, y, name := record[0], record[1], record[3:]
+
+		// renames and copies are followed by the original path
+		if x == 'R' || x == 'C' {
+			i++
+		}
+
+		status := statuses[name]
+		switch {
+		case x == '?' && y == '?':
+			status.untracked = true
+		case x == '!' && y == '!':
+			status.ignored = true
+		default:
+			status.staged = x != ' '
+			status.modified = y != ' '
+		}
+		statuses[name] = status
+	}
+
+	return statuses
+}
+
+// markGit sets the state of every entry and rolls the changes up to the directories
+func markGit(nodes []*node, root, prefix string, statuses map[string]gitStatus) {
+
+	// every parent of a changed path contains changes
+	changed := make(map[string]bool)
+	for name, status := range statuses {
+		if status.ignored {
+			continue
+		}
+		for dir := path.Dir(strings.TrimSuffix(name, "/")); dir != "." && dir != "/"; dir = path.Dir(dir) {
+			changed[dir] = true
+		}
+	}
+
+	var mark func(nodes []*node, parent gitStatus)
+	mark = func(nodes []*node, parent gitStatus) {
+		for _, n := range nodes {
+			rel := strings.TrimPrefix(n.path, root+string(os.PathSeparator))
+			name := prefix + strings.ReplaceAll(rel, string(os.PathSeparator), "/")
+
+			// whole directories are reported once for all of their content
+			status := statuses[name]
+			if dir, ok := statuses[name+"/"]; ok {
+				status = dir
+			}
+			if parent.ignored || parent.untracked {
+				status.ignored, status.untracked = parent.ignored, parent.untracked
+			}
+
+			if n.isDir {
+				status.changes = changed[name] && !status.untracked && !status.ignored
+				mark(n.children, status)
+			}
+
+			n.git = status
+		}
+	}
+
+	mark(nodes, gitStatus{})
+}
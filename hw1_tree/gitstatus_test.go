@@ -0,0 +1,78 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+)
+
+const testGitResult = `├───.gitignore (6b)
+├───build (ignored)
+│	└───app.bin (3b, ignored)
+├───docs (contains changes)
+│	├───guide.txt (6b, modified)
+│	└───todo.txt (5b, untracked)
+├───main.txt (5b, staged)
+└───stable
+	└───readme.txt (7b)
+`
+
+func TestTreeGit(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	gitRun := func(args ...string) {
+		args = append([]string{"-C", dir, "-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)
+		if output, err := exec.Command("git", args...).CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v\n%s", args, err, output)
+		}
+	}
+
+	gitRun("init", "-q")
+	write(".gitignore", "build\n")
+	write("stable/readme.txt", "stable\n")
+	write("docs/guide.txt", "guide\n")
+	write("main.txt", "main\n")
+	gitRun("add", ".")
+	gitRun("commit", "-q", "-m", "initial")
+
+	write("docs/guide.txt", "GUIDE\n")
+	write("docs/todo.txt", "todo\n")
+	write("main.txt", "MAIN\n")
+	write("build/app.bin", "bin")
+	gitRun("add", "main.txt")
+
+	out := new(bytes.Buffer)
+	if err := printTree(out, dir, options{printFiles: true, git: true}); err != nil {
+		t.Fatalf("test for OK Failed - error: %v", err)
+	}
+
+	result := out.String()
+	if result != testGitResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testGitResult)
+	}
+}
+
+func TestTreeGitOutsideRepository(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git is not installed")
+	}
+
+	out := new(bytes.Buffer)
+	if err := printTree(out, t.TempDir(), options{git: true}); err == nil {
+		t.Errorf("expected an error outside of a repository")
+	}
+}
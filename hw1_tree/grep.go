@@ -0,0 +1,110 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io"
+	"os"
+	"regexp"
+	"runtime"
+	"sync"
+)
+
+// sniffLen is how many leading bytes are checked to tell binary files from text
+const sniffLen = 8000
+
+// grepFiles counts the lines matching re in every file of the tree, several files at a time
+func grepFiles(nodes []*node, re *regexp.Regexp) {
+
+	files := make(chan *node)
+	wg := &sync.WaitGroup{}
+
+	// starting a worker pool
+	for i := 0; i < runtime.NumCPU(); i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for n := range files {
+				n.matches = countMatches(n.path, re)
+			}
+		}()
+	}
+
+	walkFiles(nodes, func(n *node) {
+		files <- n
+	})
+	close(files)
+
+	wg.Wait()
+}
+
+func walkFiles(nodes []*node, visit func(n *node)) {
+	for _, n := range nodes {
+		if n.isDir {
+			walkFiles(n.children, visit)
+		} else {
+			visit(n)
+		}
+	}
+}
+
+// countMatches returns the number of matching lines in a file, binary and unreadable files have none
+func countMatches(path string, re *regexp.Regexp) int {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return 0
+	}
+	defer file.Close()
+
+	reader := bufio.NewReaderSize(file, sniffLen)
+	if isBinary(reader) {
+		return 0
+	}
+
+	var count int
+	for {
+		line, err := reader.ReadBytes('\n')
+		if len(line) == 0 && err != nil {
+			break
+		}
+		if re.Match(bytes.TrimRight(line, "\r\n")) {
+			count++
+		}
+		if err != nil {
+			break
+		}
+	}
+
+	return count
+}
+
+// isBinary reports whether the beginning of the content contains a NUL byte, as git does
+func isBinary(reader *bufio.Reader) bool {
+	head, err := reader.Peek(sniffLen)
+	if err != nil && err != io.EOF && err != bufio.ErrBufferFull {
+		return true
+	}
+	return bytes.IndexByte(head, 0) >= 0
+}
+
+// pruneUnmatched removes files without matches and directories left without content
+func pruneUnmatched(nodes []*node) []*node {
+
+	kept := nodes[:0]
+
+	for _, n := range nodes {
+		if n.isDir {
+			n.children = pruneUnmatched(n.children)
+			if len(n.children) == 0 {
+				continue
+			}
+		} else if n.matches == 0 {
+			continue
+		}
+
+		kept = append(kept, n)
+	}
+
+	return kept
+}
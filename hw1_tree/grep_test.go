@@ -0,0 +1,38 @@
+package main
+
+import (
+	"bytes"
+	"testing"
+)
+
+const testGrepResult = `├───project
+│	└───file.txt (19b, 1 matches)
+└───static
+	├───css
+	│	└───body.css (28b, 1 matches)
+	└───html
+		└───index.html (57b, 4 matches)
+`
+
+func TestTreeGrep(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := run(out, []string{"testdata", "--grep", "[a-z]{4}"})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if result != testGrepResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testGrepResult)
+	}
+}
+
+func TestTreeGrepSkipsBinary(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := run(out, []string{"--grep", "IHDR", "testdata"})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	if out.Len() != 0 {
+		t.Errorf("binary files must not be searched\nGot:\n%v", out.String())
+	}
+}
@@ -2,27 +2,112 @@ package main
 
 import (
 	"errors"
+	"flag"
 	"fmt"
 	"io"
 	"os"
+	"regexp"
 	"strconv"
 	"strings"
 )
 
+const usage = "usage: tree DIR [-f] [--grep REGEX] [--type-info] [--git] | tree serve | tree snapshot | tree verify"
+
 func main() {
 	out := os.Stdout
-	if !(len(os.Args) == 2 || len(os.Args) == 3) {
-		panic("usage go run main.go . [-f]")
+	err := run(out, os.Args[1:])
+	if errors.Is(err, errDrift) {
+		os.Exit(1)
 	}
-	path := os.Args[1]
-	printFiles := len(os.Args) == 3 && os.Args[2] == "-f"
-	err := dirTree(out, path, printFiles)
 	if err != nil {
 		panic(err.Error())
 	}
 }
 
+// run parses the command line and prints the requested tree
+func run(out io.Writer, args []string) error {
+
+	if len(args) > 0 {
+		switch args[0] {
+		case "serve":
+			return runServe(args[1:])
+		case "snapshot":
+			return runSnapshot(out, args[1:])
+		case "verify":
+			return runVerify(out, args[1:])
+		}
+	}
+
+	flags := flag.NewFlagSet("tree", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	printFiles := flags.Bool("f", false, "print files")
+	pattern := flags.String("grep", "", "print only files whose content matches REGEX")
+	typeInfo := flags.Bool("type-info", false, "print the content type of files")
+	gitInfo := flags.Bool("git", false, "print the git working tree status of entries")
+
+	// the path may stand both before and after the flags
+	var positional []string
+	for {
+		if err := flags.Parse(args); err != nil {
+			return fmt.Errorf("%s: %v", usage, err)
+		}
+		args = flags.Args()
+		if len(args) == 0 {
+			break
+		}
+		positional = append(positional, args[0])
+		args = args[1:]
+	}
+
+	if len(positional) != 1 {
+		return errors.New(usage)
+	}
+
+	// the columns describe files, so asking for them implies -f
+	opts := options{printFiles: *printFiles || *typeInfo, typeInfo: *typeInfo, git: *gitInfo}
+
+	if *pattern != "" {
+		re, err := regexp.Compile(*pattern)
+		if err != nil {
+			return err
+		}
+		opts.grep = re
+		opts.printFiles = true
+	}
+
+	return printTree(out, positional[0], opts)
+}
+
+// options defines what is collected and printed for each entry
+type options struct {
+	printFiles bool
+	depth      int // the number of levels to read, 0 means no limit
+	grep       *regexp.Regexp
+	typeInfo   bool
+	git        bool
+}
+
+// node is a single entry of the tree
+type node struct {
+	name     string
+	path     string
+	isDir    bool
+	size     int64 // -1 if the size could not be determined
+	matches  int
+	children []*node
+
+	contentType   string // sniffed from the content
+	extensionType string // expected from the extension, set only if it disagrees with the content
+
+	git gitStatus
+}
+
 func dirTree(out io.Writer, path string, printFiles bool) error {
+	return printTree(out, path, options{printFiles: printFiles})
+}
+
+func printTree(out io.Writer, path string, opts options) error {
 
 	// checking the parameters
 	if out == nil {
@@ -33,73 +118,151 @@ func dirTree(out io.Writer, path string, printFiles bool) error {
 		return errors.New("invalid parameters: empty path")
 	}
 
+	// building a tree
+	nodes, err := buildTree(path, opts)
+	if err != nil {
+		return err
+	}
+
 	// printing a tree
-	return scanLevel(out, path, printFiles, "")
+	writeLevel(out, nodes, opts, "")
+	return nil
+}
+
+// buildTree reads the tree under path and collects everything the options ask for
+func buildTree(path string, opts options) ([]*node, error) {
+
+	nodes, err := scanLevel(path, opts, 1)
+	if err != nil {
+		return nil, err
+	}
+
+	if opts.grep != nil {
+		grepFiles(nodes, opts.grep)
+		nodes = pruneUnmatched(nodes)
+	}
+
+	if opts.typeInfo {
+		detectTypes(nodes)
+	}
+
+	if opts.git {
+		if err := annotateGit(nodes, path); err != nil {
+			return nil, err
+		}
+		nodes = skipGitDirs(nodes)
+	}
+
+	return nodes, nil
 }
 
-func scanLevel(out io.Writer, path string, printFiles bool, prefix string) error {
+func scanLevel(path string, opts options, level int) ([]*node, error) {
 
 	// reading the contents of the path
 	entries, err := os.ReadDir(path)
 	if err != nil {
-		return err
+		return nil, err
 	}
 
 	// if the files do not need to be printed, then delete them from the list
-	if !printFiles {
+	if !opts.printFiles {
 		entries = cleanFromFiles(entries)
 	}
 
-	// printing the contents of the list
-	for index, entry := range entries {
-		if entry.IsDir() {
+	nodes := make([]*node, 0, len(entries))
 
-			// defining a new prefix
-			var newPrefix string
-			var branch rune
-			if index < len(entries)-1 {
-				branch = '├'
-				newPrefix = prefix + "│\t"
-			} else {
-				branch = '└'
-				newPrefix = prefix + "\t"
+	for _, entry := range entries {
+		n := &node{
+			name:  entry.Name(),
+			path:  strings.Join([]string{path, entry.Name()}, string(os.PathSeparator)),
+			isDir: entry.IsDir(),
+			size:  -1,
+		}
+
+		if n.isDir {
+
+			// directories beyond the depth limit are shown without content
+			if opts.depth == 0 || level < opts.depth {
+				if n.children, err = scanLevel(n.path, opts, level+1); err != nil {
+					return nil, err
+				}
 			}
+		} else if info, err := entry.Info(); err == nil {
+			n.size = info.Size()
+		}
 
-			fmt.Fprintf(out, "%s%c───%s\n", prefix, branch, entry.Name())
+		nodes = append(nodes, n)
+	}
 
-			// getting ready to print a subdirectory
-			newPath := strings.Join([]string{path, entry.Name()}, string(os.PathSeparator))
+	return nodes, nil
+}
 
-			if err = scanLevel(out, newPath, printFiles, newPrefix); err != nil {
-				return err
-			}
+func writeLevel(out io.Writer, nodes []*node, opts options, prefix string) {
 
+	// printing the contents of the list
+	for index, n := range nodes {
+
+		// defining the branch symbol and a new prefix
+		var newPrefix string
+		var branch rune
+		if index < len(nodes)-1 {
+			branch = '├'
+			newPrefix = prefix + "│\t"
 		} else {
+			branch = '└'
+			newPrefix = prefix + "\t"
+		}
 
-			// determining the file size
-			info, err := entry.Info()
-			var size string
-			if err != nil {
-				size = "unknown"
-			} else if info.Size() == 0 {
-				size = "empty"
+		if n.isDir {
+			if status := n.git.String(); opts.git && status != "" {
+				fmt.Fprintf(out, "%s%c───%s (%s)\n", prefix, branch, n.name, status)
 			} else {
-				size = strconv.Itoa(int(info.Size())) + "b"
+				fmt.Fprintf(out, "%s%c───%s\n", prefix, branch, n.name)
 			}
+			writeLevel(out, n.children, opts, newPrefix)
+			continue
+		}
 
-			// defining the branch symbol
-			var branch rune
-			if index < len(entries)-1 {
-				branch = '├'
-			} else {
-				branch = '└'
-			}
+		fmt.Fprintf(out, "%s%c───%s (%s)\n", prefix, branch, n.name, strings.Join(describe(n, opts), ", "))
+	}
+}
 
-			fmt.Fprintf(out, "%s%c───%s (%s)\n", prefix, branch, entry.Name(), size)
+// describe returns the annotations printed in brackets after a file name
+func describe(n *node, opts options) []string {
+
+	// determining the file size
+	var size string
+	if n.size < 0 {
+		size = "unknown"
+	} else if n.size == 0 {
+		size = "empty"
+	} else {
+		size = strconv.Itoa(int(n.size)) + "b"
+	}
+
+	annotations := []string{size}
+
+	if opts.grep != nil {
+		annotations = append(annotations, strconv.Itoa(n.matches)+" matches")
+	}
+
+	if opts.typeInfo {
+		contentType := n.contentType
+		if contentType == "" {
+			contentType = "unknown"
+		}
+		annotations = append(annotations, contentType)
+
+		if n.extensionType != "" {
+			annotations = append(annotations, "MISMATCH: extension says "+n.extensionType)
 		}
 	}
 
-	return nil
+	if status := n.git.String(); opts.git && status != "" {
+		annotations = append(annotations, status)
+	}
+
+	return annotations
 }
 
 func cleanFromFiles(entries []os.DirEntry) []os.DirEntry {
@@ -0,0 +1,217 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"html/template"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const serveUsage = "usage: tree serve [--root DIR] [--addr :8080]"
+
+// the timeouts keep slow or stalled clients from holding connections forever,
+// writing leaves room for listing a large tree
+const (
+	serveHeaderTimeout = 5 * time.Second
+	serveReadTimeout   = 10 * time.Second
+	serveWriteTimeout  = time.Minute
+	serveIdleTimeout   = 2 * time.Minute
+)
+
+var errOutsideRoot = errors.New("path is outside of the root")
+
+// runServe parses the serve command line and serves trees until the server fails
+func runServe(args []string) error {
+
+	flags := flag.NewFlagSet("serve", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	root := flags.String("root", ".", "directory to serve")
+	addr := flags.String("addr", ":8080", "address to listen on")
+
+	if err := flags.Parse(args); err != nil || flags.NArg() != 0 {
+		return errors.New(serveUsage)
+	}
+
+	server, err := newTreeServer(*root)
+	if err != nil {
+		return err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/tree", server)
+
+	httpServer := &http.Server{
+		Addr:              *addr,
+		Handler:           mux,
+		ReadHeaderTimeout: serveHeaderTimeout,
+		ReadTimeout:       serveReadTimeout,
+		WriteTimeout:      serveWriteTimeout,
+		IdleTimeout:       serveIdleTimeout,
+	}
+	return httpServer.ListenAndServe()
+}
+
+// treeServer answers GET /tree?path=sub/dir&depth=2&files=1 with a tree under root
+type treeServer struct {
+	root string
+}
+
+func newTreeServer(root string) (*treeServer, error) {
+
+	root, err := filepath.Abs(root)
+	if err != nil {
+		return nil, err
+	}
+
+	// symbolic links are resolved so that the containment check compares real paths
+	root, err = filepath.EvalSymlinks(root)
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := os.Stat(root)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", root)
+	}
+
+	return &treeServer{root: root}, nil
+}
+
+// treeJSON is a node as it is sent to clients
+type treeJSON struct {
+	Name     string      `json:"name"`
+	Type     string      `json:"type"`
+	Size     *int64      `json:"size,omitempty"`
+	Children []*treeJSON `json:"children,omitempty"`
+}
+
+func (s *treeServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+
+	// checking the request
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.Header().Set("Allow", "GET, HEAD")
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	query := r.URL.Query()
+	opts := options{printFiles: query.Get("files") == "1"}
+
+	if value := query.Get("depth"); value != "" {
+		depth, err := strconv.Atoi(value)
+		if err != nil || depth < 0 {
+			http.Error(w, "depth must be a non-negative integer", http.StatusBadRequest)
+			return
+		}
+		opts.depth = depth
+	}
+
+	path, err := s.resolve(query.Get("path"))
+	if errors.Is(err, errOutsideRoot) {
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	} else if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// building the tree
+	nodes, err := buildTree(path, opts)
+	if errors.Is(err, os.ErrNotExist) {
+		http.Error(w, "not found", http.StatusNotFound)
+		return
+	} else if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	name := filepath.ToSlash(strings.TrimPrefix(path, s.root))
+	if name == "" {
+		name = "/"
+	}
+	tree := &treeJSON{Name: name, Type: "dir", Children: toJSON(nodes)}
+
+	// sending the tree in the requested format
+	if strings.Contains(r.Header.Get("Accept"), "text/html") {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := treePage.Execute(w, tree); err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(tree)
+}
+
+// resolve turns a slash separated path relative to the root into a real path inside the root
+func (s *treeServer) resolve(rel string) (string, error) {
+
+	// cleaning against "/" drops every ".." that would climb above the root
+	clean := filepath.Clean("/" + filepath.FromSlash(rel))
+	path, err := filepath.EvalSymlinks(filepath.Join(s.root, clean))
+	if err != nil {
+		return "", err
+	}
+
+	// a symbolic link inside the root may still point outside of it
+	if path != s.root && !strings.HasPrefix(path, s.root+string(os.PathSeparator)) {
+		return "", errOutsideRoot
+	}
+
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", err
+	}
+	if !info.IsDir() {
+		return "", fmt.Errorf("%s is not a directory", rel)
+	}
+
+	return path, nil
+}
+
+func toJSON(nodes []*node) []*treeJSON {
+
+	result := make([]*treeJSON, 0, len(nodes))
+
+	for _, n := range nodes {
+		item := &treeJSON{Name: n.name, Type: "file"}
+		if n.isDir {
+			item.Type = "dir"
+			item.Children = toJSON(n.children)
+		} else if n.size >= 0 {
+			size := n.size
+			item.Size = &size
+		}
+		result = append(result, item)
+	}
+
+	return result
+}
+
+var treePage = template.Must(template.New("page").Parse(`<!doctype html>
+<html>
+<head><meta charset="utf-8"><title>{{.Name}}</title></head>
+<body>
+<h1>{{.Name}}</h1>
+{{template "list" .Children}}
+</body>
+</html>
+{{define "list"}}{{if .}}<ul>
+{{range .}}<li>{{.Name}}{{if .Size}} ({{.Size}}b){{end}}{{template "list" .Children}}</li>
+{{end}}</ul>{{end}}{{end}}`))
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func serveTree(t *testing.T, target, accept string) *httptest.ResponseRecorder {
+	server, err := newTreeServer("testdata")
+	if err != nil {
+		t.Fatalf("can't create server: %v", err)
+	}
+	request := httptest.NewRequest(http.MethodGet, target, nil)
+	if accept != "" {
+		request.Header.Set("Accept", accept)
+	}
+	recorder := httptest.NewRecorder()
+	server.ServeHTTP(recorder, request)
+	return recorder
+}
+
+func TestServeJSON(t *testing.T) {
+	recorder := serveTree(t, "/tree?path=static&depth=1&files=1", "")
+	if recorder.Code != http.StatusOK {
+		t.Fatalf("wrong status\nGot: %d\nExpected: %d", recorder.Code, http.StatusOK)
+	}
+
+	tree := &treeJSON{}
+	if err := json.Unmarshal(recorder.Body.Bytes(), tree); err != nil {
+		t.Fatalf("can't decode response: %v", err)
+	}
+
+	var names []string
+	for _, child := range tree.Children {
+		names = append(names, child.Name)
+		if len(child.Children) != 0 {
+			t.Errorf("%s is deeper than the requested depth", child.Name)
+		}
+	}
+
+	expected := "a_lorem css empty.txt html js z_lorem"
+	if result := strings.Join(names, " "); tree.Name != "/static" || result != expected {
+		t.Errorf("results not match\nGot: %s %s\nExpected: /static %s", tree.Name, result, expected)
+	}
+}
+
+func TestServeHTML(t *testing.T) {
+	recorder := serveTree(t, "/tree?path=project&files=1", "text/html")
+	body := recorder.Body.String()
+	if !strings.HasPrefix(recorder.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "<li>file.txt (19b)</li>") {
+		t.Errorf("expected an html page\nGot:\n%s", body)
+	}
+}
+
+func TestServeTraversal(t *testing.T) {
+	for _, target := range []string{"/tree?path=../..", "/tree?path=static/../../..", "/tree?path=/etc"} {
+		recorder := serveTree(t, target, "")
+		if recorder.Code == http.StatusOK && !strings.Contains(recorder.Body.String(), `"name":"/"`) {
+			t.Errorf("%s escaped the root:\n%s", target, recorder.Body.String())
+		}
+	}
+
+	recorder := serveTree(t, "/tree?path=missing", "")
+	if recorder.Code != http.StatusNotFound {
+		t.Errorf("wrong status\nGot: %d\nExpected: %d", recorder.Code, http.StatusNotFound)
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+)
+
+const (
+	snapshotUsage = "usage: tree snapshot DIR > manifest.json"
+	verifyUsage   = "usage: tree verify DIR manifest.json"
+)
+
+// errDrift is returned by verify when the directory does not match the manifest
+var errDrift = errors.New("directory does not match the manifest")
+
+// manifest is the recorded state of a directory
+type manifest struct {
+	Entries []manifestEntry `json:"entries"`
+}
+
+// manifestEntry describes a single entry, the path is slash separated and relative to the directory
+type manifestEntry struct {
+	Path string `json:"path"`
+	Type string `json:"type"`
+	Size int64  `json:"size"`
+	Mode string `json:"mode"`
+	Hash string `json:"sha256,omitempty"`
+}
+
+func runSnapshot(out io.Writer, args []string) error {
+
+	if len(args) != 1 {
+		return errors.New(snapshotUsage)
+	}
+
+	m, err := takeSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+
+	encoder := json.NewEncoder(out)
+	encoder.SetIndent("", "\t")
+	return encoder.Encode(m)
+}
+
+func runVerify(out io.Writer, args []string) error {
+
+	if len(args) != 2 {
+		return errors.New(verifyUsage)
+	}
+
+	data, err := os.ReadFile(args[1])
+	if err != nil {
+		return err
+	}
+
+	expected := &manifest{}
+	if err := json.Unmarshal(data, expected); err != nil {
+		return fmt.Errorf("invalid manifest %s: %v", args[1], err)
+	}
+
+	actual, err := takeSnapshot(args[0])
+	if err != nil {
+		return err
+	}
+
+	drift := compareSnapshots(expected, actual)
+	for _, line := range drift {
+		fmt.Fprintln(out, line)
+	}
+
+	if len(drift) != 0 {
+		return errDrift
+	}
+	return nil
+}
+
+// takeSnapshot records every entry under root, the root itself is not included
+func takeSnapshot(root string) (*manifest, error) {
+
+	m := &manifest{Entries: []manifestEntry{}}
+
+	err := filepath.WalkDir(root, func(path string, entry fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if path == root {
+			return nil
+		}
+
+		info, err := entry.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(root, path)
+		if err != nil {
+			return err
+		}
+
+		item := manifestEntry{
+			Path: filepath.ToSlash(rel),
+			Mode: info.Mode().Perm().String(),
+		}
+
+		switch {
+		case info.IsDir():
+			item.Type = "dir"
+		case info.Mode()&fs.ModeSymlink != 0:
+			item.Type = "symlink"
+			target, err := os.Readlink(path)
+			if err != nil {
+				return err
+			}
+			item.Size = int64(len(target))
+			item.Hash = hashBytes([]byte(target))
+		case info.Mode().IsRegular():
+			item.Type = "file"
+			item.Size = info.Size()
+			if item.Hash, err = hashFile(path); err != nil {
+				return err
+			}
+		default:
+			item.Type = "other"
+		}
+
+		m.Entries = append(m.Entries, item)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return m, nil
+}
+
+func hashFile(path string) (string, error) {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(hash.Sum(nil)), nil
+}
+
+func hashBytes(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// compareSnapshots describes every difference between the manifests, sorted by path
+func compareSnapshots(expected, actual *manifest) []string {
+
+	recorded := make(map[string]manifestEntry, len(expected.Entries))
+	for _, entry := range expected.Entries {
+		recorded[entry.Path] = entry
+	}
+
+	found := make(map[string]manifestEntry, len(actual.Entries))
+	for _, entry := range actual.Entries {
+		found[entry.Path] = entry
+	}
+
+	var paths []string
+	for path := range recorded {
+		paths = append(paths, path)
+	}
+	for path := range found {
+		if _, ok := recorded[path]; !ok {
+			paths = append(paths, path)
+		}
+	}
+	sort.Strings(paths)
+
+	var drift []string
+
+	for _, path := range paths {
+		was, inManifest := recorded[path]
+		is, onDisk := found[path]
+
+		switch {
+		case !onDisk:
+			drift = append(drift, "missing  "+path)
+		case !inManifest:
+			drift = append(drift, "extra    "+path)
+		default:
+			var changes []string
+			if was.Type != is.Type {
+				changes = append(changes, "type "+was.Type+" -> "+is.Type)
+			}
+			if was.Size != is.Size {
+				changes = append(changes, fmt.Sprintf("size %d -> %d", was.Size, is.Size))
+			}
+			if was.Mode != is.Mode {
+				changes = append(changes, "mode "+was.Mode+" -> "+is.Mode)
+			}
+			if was.Hash != is.Hash && was.Size == is.Size {
+				changes = append(changes, "content")
+			}
+			if len(changes) != 0 {
+				drift = append(drift, "modified "+path+" ("+strings.Join(changes, ", ")+")")
+			}
+		}
+	}
+
+	return drift
+}
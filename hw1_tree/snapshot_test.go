@@ -0,0 +1,68 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestSnapshotVerify(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) {
+		path := filepath.Join(dir, filepath.FromSlash(name))
+		if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	write("app/main.bin", "binary")
+	write("app/config.txt", "debug=false")
+	write("readme.txt", "read me")
+
+	snapshot := new(bytes.Buffer)
+	if err := run(snapshot, []string{"snapshot", dir}); err != nil {
+		t.Fatalf("snapshot failed: %v", err)
+	}
+
+	manifestPath := filepath.Join(t.TempDir(), "manifest.json")
+	if err := os.WriteFile(manifestPath, snapshot.Bytes(), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	// an untouched directory matches its manifest
+	out := new(bytes.Buffer)
+	if err := run(out, []string{"verify", dir, manifestPath}); err != nil || out.Len() != 0 {
+		t.Fatalf("expected no drift, got %v:\n%s", err, out.String())
+	}
+
+	write("app/config.txt", "debug=true!")
+	write("app/main.bin", "BINARY")
+	write("extra.txt", "")
+	if err := os.Remove(filepath.Join(dir, "readme.txt")); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.Chmod(filepath.Join(dir, "app"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `modified app (mode -rwxr-xr-x -> -rwx------)
+modified app/config.txt (content)
+modified app/main.bin (content)
+extra    extra.txt
+missing  readme.txt
+`
+
+	out.Reset()
+	err := run(out, []string{"verify", dir, manifestPath})
+	if !errors.Is(err, errDrift) {
+		t.Errorf("expected drift error, got %v", err)
+	}
+	if result := out.String(); result != expected {
+		t.Errorf("results not match\nGot:\n%v\nExpected:\n%v", result, expected)
+	}
+}
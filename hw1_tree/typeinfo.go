@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io"
+	"mime"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// emptyType is reported for empty files, the way file(1) does it
+const emptyType = "inode/x-empty"
+
+// detectTypes sniffs the content type of every file in the tree by its magic bytes
+func detectTypes(nodes []*node) {
+	walkFiles(nodes, func(n *node) {
+		n.contentType = sniffType(n.path)
+		if n.contentType == "" || n.contentType == emptyType {
+			return
+		}
+
+		expected := mediaType(mime.TypeByExtension(filepath.Ext(n.name)))
+		if expected != "" && !compatibleTypes(n.contentType, expected) {
+			n.extensionType = expected
+		}
+	})
+}
+
+// sniffType returns the media type of the file content or an empty string if it can't be read
+func sniffType(path string) string {
+
+	file, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer file.Close()
+
+	// http.DetectContentType considers at most 512 bytes
+	head := make([]byte, 512)
+	count, err := io.ReadFull(file, head)
+	if err != nil && err != io.EOF && err != io.ErrUnexpectedEOF {
+		return ""
+	}
+	if count == 0 {
+		return emptyType
+	}
+
+	return mediaType(http.DetectContentType(head[:count]))
+}
+
+// mediaType drops the parameters, such as charset, from a content type
+func mediaType(contentType string) string {
+	return strings.TrimSpace(strings.SplitN(contentType, ";", 2)[0])
+}
+
+// compatibleTypes reports whether the sniffed type agrees with the one expected from the extension
+func compatibleTypes(sniffed, expected string) bool {
+	if sniffed == expected {
+		return true
+	}
+
+	// sniffing can't tell text formats apart, so any textual extension fits plain text
+	if sniffed == "text/plain" {
+		return strings.HasPrefix(expected, "text/") ||
+			strings.HasSuffix(expected, "+xml") ||
+			strings.HasSuffix(expected, "+json") ||
+			expected == "application/javascript" ||
+			expected == "application/json" ||
+			expected == "application/xml"
+	}
+
+	// the same goes for xml documents
+	if sniffed == "text/xml" {
+		return strings.HasSuffix(expected, "xml")
+	}
+
+	return false
+}
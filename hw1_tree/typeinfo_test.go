@@ -0,0 +1,58 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const testTypeResult = `├───project
+│	├───file.txt (19b, text/plain)
+│	└───gopher.png (70372b, image/png)
+`
+
+func TestTreeTypeInfo(t *testing.T) {
+	out := new(bytes.Buffer)
+	err := run(out, []string{"testdata", "--type-info"})
+	if err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if !bytes.HasPrefix(out.Bytes(), []byte(testTypeResult)) {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testTypeResult)
+	}
+}
+
+const testMismatchResult = `├───logo.gif (70372b, image/png, MISMATCH: extension says image/gif)
+├───logo.png (70372b, image/png)
+└───page.html (57b, text/html)
+`
+
+func TestTreeTypeMismatch(t *testing.T) {
+	dir := t.TempDir()
+
+	png, err := os.ReadFile(filepath.Join("testdata", "project", "gopher.png"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	html, err := os.ReadFile(filepath.Join("testdata", "static", "html", "index.html"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, content := range map[string][]byte{"logo.png": png, "logo.gif": png, "page.html": html} {
+		if err := os.WriteFile(filepath.Join(dir, name), content, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	out := new(bytes.Buffer)
+	if err := run(out, []string{dir, "--type-info"}); err != nil {
+		t.Errorf("test for OK Failed - error: %v", err)
+	}
+	result := out.String()
+	if result != testMismatchResult {
+		t.Errorf("test for OK Failed - results not match\nGot:\n%v\nExpected:\n%v", result, testMismatchResult)
+	}
+}
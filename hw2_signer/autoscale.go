@@ -0,0 +1,148 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// scaleInterval is how often an autoscaling pool looks at its queue
+	scaleInterval = 10 * time.Millisecond
+	// idleChecks is how many checks in a row find an idle worker before the pool lets one go
+	idleChecks = 5
+)
+
+// scaler sizes a worker pool by the pressure on its queue, a nil scaler keeps the pool as it is
+type scaler struct {
+	waiting  int32 // the feeder holds a value no worker is free to take
+	workers  int32
+	busy     int32
+	finished int64 // the items processed since the last check
+	spent    int64 // the nanoseconds spent on them
+
+	min, max int32
+	latency  time.Duration
+	retire   chan struct{} // an idle worker taking a value from it leaves the pool
+	fed      chan struct{} // closed once the input is over and the pool has nothing left to grow for
+}
+
+// newScaler returns the scaler of an autoscaling pool and nil for a fixed one
+func newScaler(c config) *scaler {
+	if c.maxWorkers <= c.workers {
+		return nil
+	}
+	return &scaler{
+		min:     int32(c.workers),
+		max:     int32(c.maxWorkers),
+		latency: c.latency,
+		retire:  make(chan struct{}),
+		fed:     make(chan struct{}),
+	}
+}
+
+// feed passes the item to the workers unless ctx is done first, waiting for a worker counts as pressure
+func feed[T any](ctx context.Context, s *scaler, queue chan T, item T) error {
+	if s == nil {
+		return send(ctx, queue, item)
+	}
+
+	select {
+	case queue <- item:
+		return nil
+	default:
+	}
+
+	atomic.StoreInt32(&s.waiting, 1)
+	defer atomic.StoreInt32(&s.waiting, 0)
+	return send(ctx, queue, item)
+}
+
+// done tells the scaler the input is over
+func (s *scaler) done() {
+	if s != nil {
+		close(s.fed)
+	}
+}
+
+// retired returns the channel telling an idle worker to leave, nil for a fixed pool
+func (s *scaler) retired() chan struct{} {
+	if s == nil {
+		return nil
+	}
+	return s.retire
+}
+
+// joined changes the number of workers in the pool, a nil scaler ignores it
+func (s *scaler) joined(n int) {
+	if s != nil {
+		atomic.AddInt32(&s.workers, int32(n))
+	}
+}
+
+// working changes the number of busy workers, a nil scaler ignores it
+func (s *scaler) working(n int) {
+	if s != nil {
+		atomic.AddInt32(&s.busy, int32(n))
+	}
+}
+
+// processed accounts the time an item took, a nil scaler ignores it
+func (s *scaler) processed(d time.Duration) {
+	if s != nil {
+		atomic.AddInt64(&s.finished, 1)
+		atomic.AddInt64(&s.spent, int64(d))
+	}
+}
+
+// run checks the pool every scaleInterval until the input is over or ctx is done.
+// It calls grow to add a worker while the feeder waits and the items are fast enough,
+// and lets a worker go once some stayed idle for idleChecks checks.
+func (s *scaler) run(ctx context.Context, grow func()) error {
+
+	clock := clockFrom(ctx)
+	idle := 0
+
+	for {
+		tick := make(chan struct{})
+		stop := clock.AfterFunc(scaleInterval, func() { close(tick) })
+
+		select {
+		case <-tick:
+		case <-s.fed:
+			stop()
+			return nil
+		case <-ctx.Done():
+			// the error is reported by the stage that caused it
+			stop()
+			return nil
+		}
+
+		workers, busy := atomic.LoadInt32(&s.workers), atomic.LoadInt32(&s.busy)
+		waiting := atomic.LoadInt32(&s.waiting) == 1
+
+		finished, spent := atomic.SwapInt64(&s.finished, 0), atomic.SwapInt64(&s.spent, 0)
+		// with a target latency the pool grows only on the items measured since the last check
+		fast := s.latency <= 0 || finished != 0 && time.Duration(spent/finished) <= s.latency
+
+		switch {
+		case waiting && workers < s.max && fast:
+			idle = 0
+			grow()
+
+		case !waiting && busy < workers && workers > s.min:
+			idle++
+			if idle < idleChecks {
+				continue
+			}
+			select {
+			case s.retire <- struct{}{}:
+				idle = 0
+			default:
+			}
+
+		default:
+			idle = 0
+		}
+	}
+}
@@ -0,0 +1,184 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// gated is a process holding every value until it gets through the gate, its calls are tracked by calls
+func gated(calls *concurrency, gate chan struct{}) func(ctx context.Context, value int) (int, error) {
+	return func(ctx context.Context, value int) (int, error) {
+		calls.wrap(func(string) string {
+			<-gate
+			return ""
+		})("")
+		return value, nil
+	}
+}
+
+// poolWorkers returns the size of the pool of the second stage of the run
+func poolWorkers(m *Metrics) int64 {
+	if stages := m.Snapshot().Stages; len(stages) > 1 {
+		return stages[1].Workers
+	}
+	return 0
+}
+
+// tick moves the clock to the next check of the scaler once it waits for it
+func tick(clock *FakeClock) {
+	clock.BlockUntil(1)
+	clock.Advance(scaleInterval)
+}
+
+// tickUntil checks the scaler until ok holds, the test fails if it does not after plenty of checks
+func tickUntil(t *testing.T, clock *FakeClock, ok func() bool) {
+	for i := 0; !ok(); i++ {
+		if i == 1000 {
+			t.Fatalf("no change after %d checks", i)
+		}
+		tick(clock)
+	}
+}
+
+func TestAutoscaleGrow(t *testing.T) {
+
+	calls := &concurrency{}
+	m := &Metrics{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(RecordMetrics(context.Background(), m), clock)
+
+	gate := make(chan struct{})
+
+	var result []int
+	errc := make(chan error)
+	go func() {
+		chain := Then(From(numbers(100)), Map(gated(calls, gate), WithAutoscale(1, 4)))
+		errc <- chain.Run(ctx, collect(&result))
+	}()
+
+	// the values back up while the workers hold them, the pool grows to its bound and no further
+	tickUntil(t, clock, func() bool { return poolWorkers(m) == 4 })
+	for i := 0; i < 2*idleChecks; i++ {
+		tick(clock)
+	}
+	if workers := poolWorkers(m); workers != 4 {
+		t.Errorf("pool grows beyond its bound: %d workers", workers)
+	}
+
+	close(gate)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 100 {
+		t.Errorf("wrong number of results\nGot: %d\nExpected: %d", len(result), 100)
+	}
+	if calls.max != 4 {
+		t.Errorf("pool does not grow to its bound\nGot: %d\nExpected: %d", calls.max, 4)
+	}
+
+	if workers := m.Snapshot().Stages[1].Workers; workers != 0 {
+		t.Errorf("pool is not released: %d workers", workers)
+	}
+}
+
+func TestAutoscaleShrink(t *testing.T) {
+
+	calls := &concurrency{}
+	m := &Metrics{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(RecordMetrics(context.Background(), m), clock)
+
+	// a burst of values, then the source waits until the pool shrinks back
+	stop := make(chan struct{})
+	source := func(ctx context.Context, out chan int) error {
+		if err := numbers(50)(ctx, out); err != nil {
+			return err
+		}
+		<-stop
+		return nil
+	}
+
+	received := make(chan int, 50)
+	sink := func(ctx context.Context, in chan int) error {
+		for value := range in {
+			received <- value
+		}
+		return nil
+	}
+
+	gate := make(chan struct{})
+
+	errc := make(chan error)
+	go func() {
+		errc <- Then(From(source), Map(gated(calls, gate), WithAutoscale(2, 6))).Run(ctx, sink)
+	}()
+
+	tickUntil(t, clock, func() bool { return poolWorkers(m) == 6 })
+
+	close(gate)
+	for i := 0; i < 50; i++ {
+		<-received
+	}
+
+	// the idle workers leave one by one down to the minimum
+	tickUntil(t, clock, func() bool { return poolWorkers(m) == 2 })
+	for i := 0; i < 2*idleChecks; i++ {
+		tick(clock)
+	}
+	if workers := poolWorkers(m); workers != 2 {
+		t.Errorf("idle pool shrinks below its minimum: %d workers", workers)
+	}
+
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls.max != 6 {
+		t.Errorf("pool does not grow to its bound\nGot: %d\nExpected: %d", calls.max, 6)
+	}
+}
+
+func TestAutoscaleTargetLatency(t *testing.T) {
+
+	calls := &concurrency{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(context.Background(), clock)
+
+	// the source stays open, so the scaler keeps checking until the test is over
+	stop := make(chan struct{})
+	source := func(ctx context.Context, out chan int) error {
+		if err := numbers(10)(ctx, out); err != nil {
+			return err
+		}
+		<-stop
+		return nil
+	}
+
+	release := make(chan struct{})
+
+	var result []int
+	errc := make(chan error)
+	go func() {
+		chain := Then(From(source), Map(gated(calls, release), WithAutoscale(1, 4), WithTargetLatency(5*time.Millisecond)))
+		errc <- chain.Run(ctx, collect(&result))
+	}()
+
+	// every value takes two checks while the next ones wait
+	for i := 0; i < 10; i++ {
+		tick(clock)
+		tick(clock)
+		release <- struct{}{}
+	}
+
+	close(stop)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 10 || calls.max != 1 {
+		t.Errorf("slow items should keep the pool at its minimum: %d results, %d calls at once", len(result), calls.max)
+	}
+}
@@ -0,0 +1,170 @@
+package main
+
+import (
+	"container/list"
+	"context"
+	"fmt"
+	"sync"
+)
+
+// Cache memoizes the results of a signer, it is safe for concurrent use.
+// Concurrent calls with the same data share one computation and
+// the least recently used results are evicted beyond the capacity.
+type Cache struct {
+	mu       sync.Mutex
+	capacity int
+	entries  map[string]*list.Element
+	recent   *list.List // the cached results, the most recently used first
+	flights  map[string]*flight
+	hits     int64
+	misses   int64
+}
+
+type cacheEntry struct {
+	key   string
+	value string
+}
+
+// flight is a computation other calls with the same data wait for
+type flight struct {
+	done  chan struct{}
+	value string
+	err   error
+}
+
+// CacheStats is a copy of the counters of a cache
+type CacheStats struct {
+	Hits   int64 // the calls served from the cache or by a computation already running
+	Misses int64
+	Size   int
+}
+
+// HitRate returns the share of the calls that did not compute anything
+func (s CacheStats) HitRate() float64 {
+	if s.Hits+s.Misses == 0 {
+		return 0
+	}
+	return float64(s.Hits) / float64(s.Hits+s.Misses)
+}
+
+func (s CacheStats) String() string {
+	return fmt.Sprintf("%d hits of %d calls (%.1f%%), %d cached", s.Hits, s.Hits+s.Misses, 100*s.HitRate(), s.Size)
+}
+
+// NewCache creates a cache holding at most capacity results, zero or less means no bound
+func NewCache(capacity int) *Cache {
+	return &Cache{
+		capacity: capacity,
+		entries:  make(map[string]*list.Element),
+		recent:   list.New(),
+		flights:  make(map[string]*flight),
+	}
+}
+
+// Do returns the cached result for data or computes it. Failed computations are not cached,
+// the calls that waited for one of them try again unless their ctx is done.
+func (c *Cache) Do(ctx context.Context, data string, compute func(ctx context.Context) (string, error)) (string, error) {
+	for {
+		c.mu.Lock()
+
+		if e, ok := c.entries[data]; ok {
+			c.hits++
+			c.recent.MoveToFront(e)
+			c.mu.Unlock()
+			return e.Value.(*cacheEntry).value, nil
+		}
+
+		if f, ok := c.flights[data]; ok {
+			c.mu.Unlock()
+
+			select {
+			case <-f.done:
+			case <-ctx.Done():
+				return "", ctx.Err()
+			}
+
+			if f.err == nil {
+				c.mu.Lock()
+				c.hits++
+				c.mu.Unlock()
+				return f.value, nil
+			}
+			continue
+		}
+
+		f := &flight{done: make(chan struct{})}
+		c.flights[data] = f
+		c.misses++
+		c.mu.Unlock()
+
+		f.err = safely(func() (err error) {
+			f.value, err = compute(ctx)
+			return err
+		})
+
+		c.mu.Lock()
+		delete(c.flights, data)
+		if f.err == nil {
+			c.add(data, f.value)
+		}
+		c.mu.Unlock()
+		close(f.done)
+
+		return f.value, f.err
+	}
+}
+
+// Wrap returns signer memoized by the cache
+func (c *Cache) Wrap(signer func(string) string) func(string) string {
+	return func(data string) string {
+		value, err := c.Do(context.Background(), data, func(context.Context) (string, error) {
+			return signer(data), nil
+		})
+		if err != nil {
+			panic(err.Error())
+		}
+		return value
+	}
+}
+
+// Stats copies the counters of the cache
+func (c *Cache) Stats() CacheStats {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return CacheStats{Hits: c.hits, Misses: c.misses, Size: c.recent.Len()}
+}
+
+// add caches a result evicting the least recently used ones, it is called with the mutex held
+func (c *Cache) add(key, value string) {
+	c.entries[key] = c.recent.PushFront(&cacheEntry{key: key, value: value})
+
+	for c.capacity > 0 && c.recent.Len() > c.capacity {
+		oldest := c.recent.Back()
+		c.recent.Remove(oldest)
+		delete(c.entries, oldest.Value.(*cacheEntry).key)
+	}
+}
+
+// SignerCache holds a cache for each of the signers, it may be shared by several stages
+type SignerCache struct {
+	Crc32 *Cache
+	Md5   *Cache
+}
+
+// NewSignerCache creates the caches of the signers holding at most capacity results each
+func NewSignerCache(capacity int) *SignerCache {
+	return &SignerCache{Crc32: NewCache(capacity), Md5: NewCache(capacity)}
+}
+
+func (c *SignerCache) String() string {
+	return fmt.Sprintf("crc32: %v; md5: %v", c.Crc32.Stats(), c.Md5.Stats())
+}
+
+// caches returns the caches of the crc32 and md5 signers, both are nil for a nil cache
+func (c *SignerCache) caches() (crc32, md5 *Cache) {
+	if c == nil {
+		return nil, nil
+	}
+	return c.Crc32, c.Md5
+}
@@ -0,0 +1,136 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestCacheSingleFlight(t *testing.T) {
+
+	var calls int32
+	cache := NewCache(0)
+	signer := cache.Wrap(func(data string) string {
+		atomic.AddInt32(&calls, 1)
+		time.Sleep(20 * time.Millisecond)
+		return "signed " + data
+	})
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if got := signer("x"); got != "signed x" {
+				t.Errorf("wrong result: %s", got)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if calls != 1 {
+		t.Errorf("concurrent calls are not shared, %d computations", calls)
+	}
+
+	if stats := cache.Stats(); stats.Hits != 9 || stats.Misses != 1 || stats.HitRate() != 0.9 {
+		t.Errorf("wrong stats: %v", stats)
+	}
+}
+
+func TestCacheEviction(t *testing.T) {
+
+	var calls int32
+	cache := NewCache(2)
+	signer := cache.Wrap(func(data string) string {
+		atomic.AddInt32(&calls, 1)
+		return data
+	})
+
+	// b is the least recently used when c comes
+	for _, data := range []string{"a", "b", "a", "c", "a", "b"} {
+		signer(data)
+	}
+
+	if calls != 4 {
+		t.Errorf("wrong number of computations\nGot: %d\nExpected: %d", calls, 4)
+	}
+
+	if stats := cache.Stats(); stats.Size != 2 {
+		t.Errorf("wrong cache size\nGot: %d\nExpected: %d", stats.Size, 2)
+	}
+}
+
+func TestCacheFailedFlight(t *testing.T) {
+
+	cache := NewCache(0)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	failed := func(ctx context.Context) (string, error) { return "", ctx.Err() }
+	if _, err := cache.Do(ctx, "x", failed); err == nil {
+		t.Fatalf("expected an error")
+	}
+
+	// the failure is not cached
+	value, err := cache.Do(context.Background(), "x", func(context.Context) (string, error) { return "ok", nil })
+	if err != nil || value != "ok" {
+		t.Errorf("wrong result: %q, %v", value, err)
+	}
+}
+
+func TestSignerCache(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	var md5Calls int32
+	md5 := DataSignerMd5
+	DataSignerMd5 = func(data string) string {
+		atomic.AddInt32(&md5Calls, 1)
+		return md5(data)
+	}
+
+	cache := NewSignerCache(10)
+
+	var result []string
+	repeated := func(ctx context.Context, out chan int) error {
+		for _, value := range []int{1, 1, 2, 1, 2} {
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	chain := Then(From(repeated), NewSingleHash(WithCache(cache), WithOrder()))
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 5 || result[0] != result[1] || result[0] != result[3] || result[2] != result[4] {
+		t.Errorf("wrong results: %v", result)
+	}
+
+	if md5Calls != 2 {
+		t.Errorf("wrong number of md5 calls\nGot: %d\nExpected: %d", md5Calls, 2)
+	}
+
+	if stats := cache.Md5.Stats(); stats.Hits != 3 {
+		t.Errorf("wrong md5 cache stats: %v", stats)
+	}
+
+	// the results of another salt are not the cached ones
+	salt := DataSignerSalt
+	t.Cleanup(func() { DataSignerSalt = salt })
+	DataSignerSalt = "pepper"
+
+	result = nil
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if md5Calls != 4 {
+		t.Errorf("cached results of another salt are used\nGot: %d md5 calls\nExpected: %d", md5Calls, 4)
+	}
+}
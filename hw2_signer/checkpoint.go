@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// Checkpoint is a durable log of the items the stages have completed, keyed by stage, the settings
+// the results depend on and input. A stage using it skips the items already in the log, so a restarted
+// run does only the work that is left, a run with another salt or MultiHash th starts over.
+// It is safe for concurrent use and may be shared by several stages.
+type Checkpoint struct {
+	mu       sync.Mutex
+	file     *os.File
+	done     map[checkpointKey][]byte
+	restored int64
+
+	// the records written meanwhile are synced together, flushed counts the lines on disk
+	synced   sync.Cond
+	written  int64
+	flushed  int64
+	flushing bool
+}
+
+type checkpointKey struct {
+	stage  string
+	params string
+	input  string
+}
+
+// checkpointRecord is a line of the log, the result is encoded by the gob codec
+type checkpointRecord struct {
+	Stage  string `json:"stage"`
+	Params string `json:"params,omitempty"`
+	Input  string `json:"input"`
+	Result []byte `json:"result"`
+}
+
+// OpenCheckpoint opens the log at path creating it if needed and loads the completed items.
+// A line torn by a killed run is dropped.
+func OpenCheckpoint(path string) (*Checkpoint, error) {
+
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	c := &Checkpoint{file: file, done: make(map[checkpointKey][]byte)}
+	c.synced.L = &c.mu
+
+	// the log is kept up to the last complete line
+	valid := int64(0)
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if err != nil {
+			break
+		}
+		var record checkpointRecord
+		if err := json.Unmarshal(line, &record); err != nil {
+			break
+		}
+		c.done[checkpointKey{record.Stage, record.Params, record.Input}] = record.Result
+		valid += int64(len(line))
+	}
+
+	if err := file.Truncate(valid); err != nil {
+		file.Close()
+		return nil, err
+	}
+	if _, err := file.Seek(valid, 0); err != nil {
+		file.Close()
+		return nil, err
+	}
+
+	return c, nil
+}
+
+// Len returns how many items the log holds
+func (c *Checkpoint) Len() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.done)
+}
+
+// Restored returns how many items were taken from the log instead of being processed
+func (c *Checkpoint) Restored() int64 {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.restored
+}
+
+// Close closes the log
+func (c *Checkpoint) Close() error {
+	return c.file.Close()
+}
+
+func (c *Checkpoint) lookup(key checkpointKey) ([]byte, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	result, ok := c.done[key]
+	if ok {
+		c.restored++
+	}
+	return result, ok
+}
+
+// record appends the completed item to the log and waits until it is on disk.
+// The file is synced outside the lock, the records appended meanwhile wait for the next sync together.
+func (c *Checkpoint) record(key checkpointKey, result []byte) error {
+
+	line, err := json.Marshal(checkpointRecord{Stage: key.stage, Params: key.params, Input: key.input, Result: result})
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if _, ok := c.done[key]; ok {
+		return nil
+	}
+
+	if _, err := c.file.Write(append(line, '\n')); err != nil {
+		return fmt.Errorf("checkpoint: %w", err)
+	}
+	c.done[key] = result
+	c.written++
+	mine := c.written
+
+	for c.flushed < mine {
+		if c.flushing {
+			c.synced.Wait()
+			continue
+		}
+
+		c.flushing = true
+		written := c.written
+		c.mu.Unlock()
+		err := c.file.Sync()
+		c.mu.Lock()
+		c.flushing = false
+		if err == nil {
+			c.flushed = written
+		}
+		c.synced.Broadcast()
+
+		if err != nil {
+			return fmt.Errorf("checkpoint: %w", err)
+		}
+	}
+	return nil
+}
+
+// checkpointParams describes the settings the results of the stage depend on,
+// the results recorded under other settings are not restored
+func checkpointParams(c config) string {
+	params := "salt=" + strconv.Quote(DataSignerSalt)
+	if c.params != "" {
+		params += " " + c.params
+	}
+	return params
+}
+
+// withCheckpoint makes process skip the values the checkpoint of the stage has completed
+// and record the ones it completes
+func withCheckpoint[In, Out any](c config, process func(ctx context.Context, value In) (Out, error)) func(ctx context.Context, value In) (Out, error) {
+
+	if c.checkpoint == nil {
+		return process
+	}
+
+	codec := gobCodec{}
+
+	return func(ctx context.Context, value In) (Out, error) {
+
+		key := checkpointKey{stage: c.name, params: checkpointParams(c), input: fmt.Sprintf("%v", value)}
+
+		// a result that can't be restored is computed again
+		if data, ok := c.checkpoint.lookup(key); ok {
+			if decoded, err := codec.Unmarshal(data); err == nil {
+				if result, ok := decoded.(Out); ok {
+					return result, nil
+				}
+			}
+		}
+
+		result, err := process(ctx, value)
+		if err != nil {
+			return result, err
+		}
+
+		data, err := codec.Marshal(result)
+		if err != nil {
+			return result, fmt.Errorf("checkpoint: can`t encode %v (%T): %w", result, result, err)
+		}
+		return result, c.checkpoint.record(key, data)
+	}
+}
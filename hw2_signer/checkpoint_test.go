@@ -0,0 +1,130 @@
+package main
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// signNumbers combines the signatures of the numbers from 0 to n-1, counting the crc32 calls
+func signNumbers(t *testing.T, n int, opts ...Option) (string, int32) {
+
+	var calls int32
+	crc32 := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddInt32(&calls, 1)
+		return crc32(data)
+	}
+	defer func() { DataSignerCrc32 = crc32 }()
+
+	var result []string
+	chain := Then(Then(Then(From(numbers(n)), NewSingleHash(opts...)), NewMultiHash(opts...)), NewCombineResults())
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	return result[0], calls
+}
+
+func TestCheckpointResume(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	path := filepath.Join(t.TempDir(), "checkpoint.log")
+
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signNumbers(t, 3, WithCheckpoint(checkpoint))
+	checkpoint.Close()
+
+	// the restarted run with more input does only the new items
+	checkpoint, err = OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	if checkpoint.Len() != 6 {
+		t.Errorf("wrong number of completed items\nGot: %d\nExpected: %d", checkpoint.Len(), 6)
+	}
+
+	resumed, calls := signNumbers(t, 5, WithCheckpoint(checkpoint))
+
+	// two new items, 2 crc32 calls in SingleHash and 6 in MultiHash each
+	if calls != 16 {
+		t.Errorf("wrong number of crc32 calls\nGot: %d\nExpected: %d", calls, 16)
+	}
+	if checkpoint.Restored() != 6 {
+		t.Errorf("wrong number of restored items\nGot: %d\nExpected: %d", checkpoint.Restored(), 6)
+	}
+
+	if uninterrupted, _ := signNumbers(t, 5); resumed != uninterrupted {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", resumed, uninterrupted)
+	}
+}
+
+func TestCheckpointSettings(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+	t.Cleanup(func() { DataSignerSalt = "" })
+
+	path := filepath.Join(t.TempDir(), "checkpoint.log")
+
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	signNumbers(t, 3, WithCheckpoint(checkpoint))
+
+	// the results of another salt or th are different, none of them is restored
+	DataSignerSalt = "pepper"
+	salted, _ := signNumbers(t, 3, WithCheckpoint(checkpoint))
+	DataSignerSalt = ""
+	signNumbers(t, 3, WithCheckpoint(checkpoint), WithMultiHashTh(2))
+
+	if checkpoint.Restored() != 3 {
+		t.Errorf("wrong number of restored items\nGot: %d\nExpected: %d", checkpoint.Restored(), 3)
+	}
+
+	DataSignerSalt = "pepper"
+	if uncheckpointed, _ := signNumbers(t, 3); salted != uncheckpointed {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", salted, uncheckpointed)
+	}
+}
+
+func TestCheckpointTornLine(t *testing.T) {
+
+	path := filepath.Join(t.TempDir(), "checkpoint.log")
+
+	complete := `{"stage":"SingleHash","input":"0","result":null}` + "\n"
+	if err := os.WriteFile(path, []byte(complete+`{"stage":"Sing`), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	checkpoint, err := OpenCheckpoint(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer checkpoint.Close()
+
+	if checkpoint.Len() != 1 {
+		t.Errorf("wrong number of completed items\nGot: %d\nExpected: %d", checkpoint.Len(), 1)
+	}
+
+	if err := checkpoint.record(checkpointKey{stage: "SingleHash", input: "1"}, nil); err != nil {
+		t.Fatal(err)
+	}
+
+	data, _ := os.ReadFile(path)
+	expected := complete + `{"stage":"SingleHash","input":"1","result":null}` + "\n"
+	if string(data) != expected {
+		t.Errorf("torn line is not dropped\nGot: %q\nExpected: %q", data, expected)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const usage = "usage: signer [--salt SALT] [--single-workers N] [--multi-workers N] [--max-workers N] " +
+	"[--single-remote ADDR] [--multi-remote ADDR] [--checkpoint LOG] [--drain TIMEOUT] [--trace] [FILE] | signer worker"
+
+const workerUsage = "usage: signer worker --stage SingleHash|MultiHash --listen ADDR [--salt SALT] [--workers N] [--max-workers N]"
+
+// defaultDrain is how long an interrupted signer finishes the values in flight
+const defaultDrain = 5 * time.Second
+
+// runSigner reads the values from the file named in args or from stdin, one per line,
+// and writes their combined hash to stdout. The steps are traced to stderr.
+// SIGINT or SIGTERM stops reading, the result of the values read so far is written
+// and ErrIncomplete is returned.
+func runSigner(stdin io.Reader, stdout, stderr io.Writer, args []string) error {
+
+	if len(args) > 0 && args[0] == "worker" {
+		ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+		defer stop()
+		return runWorker(ctx, args[1:])
+	}
+
+	flags := flag.NewFlagSet("signer", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	salt := flags.String("salt", "", "salt appended to the data before signing")
+	singleWorkers := flags.Int("single-workers", defaultWorkers, "number of values SingleHash signs at once")
+	multiWorkers := flags.Int("multi-workers", defaultWorkers, "number of values MultiHash signs at once")
+	maxWorkers := flags.Int("max-workers", 0, "let the pools grow up to N workers while their input backs up")
+	singleRemote := flags.String("single-remote", "", "address of a worker running SingleHash, such as tcp://localhost:7001")
+	multiRemote := flags.String("multi-remote", "", "address of a worker running MultiHash")
+	checkpointPath := flags.String("checkpoint", "", "log of the completed items, a restarted run skips them")
+	drainTimeout := flags.Duration("drain", defaultDrain, "how long an interrupted run finishes the values in flight, 0 means no limit")
+	traced := flags.Bool("trace", false, "print the steps of every value")
+
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%s: %v", usage, err)
+	}
+	if flags.NArg() > 1 {
+		return errors.New(usage)
+	}
+
+	input := stdin
+	if flags.NArg() == 1 {
+		file, err := os.Open(flags.Arg(0))
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		input = file
+	}
+
+	DataSignerSalt = *salt
+
+	var traceOut io.Writer
+	if *traced {
+		traceOut = stderr
+	}
+
+	var checkpoint *Checkpoint
+	if *checkpointPath != "" {
+		var err error
+		if checkpoint, err = OpenCheckpoint(*checkpointPath); err != nil {
+			return err
+		}
+		defer checkpoint.Close()
+	}
+
+	singleHash := Untyped("SingleHash", NewSingleHash(WithAutoscale(*singleWorkers, *maxWorkers), WithTrace(traceOut), WithCheckpoint(checkpoint)))
+	if *singleRemote != "" {
+		network, addr, err := ParseAddress(*singleRemote)
+		if err != nil {
+			return err
+		}
+		singleHash = RemoteStage(network, addr, WithName("SingleHash"))
+	}
+
+	multiHash := Untyped("MultiHash", NewMultiHash(WithAutoscale(*multiWorkers, *maxWorkers), WithTrace(traceOut), WithCheckpoint(checkpoint)))
+	if *multiRemote != "" {
+		network, addr, err := ParseAddress(*multiRemote)
+		if err != nil {
+			return err
+		}
+		multiHash = RemoteStage(network, addr, WithName("MultiHash"))
+	}
+
+	shutdown := NewShutdown(*drainTimeout)
+	defer shutdown.Notify(os.Interrupt, syscall.SIGTERM)()
+
+	var result string
+
+	err := ExecutePipelineContext(ShutdownWith(context.Background(), shutdown),
+		lines(input),
+		singleHash,
+		multiHash,
+		Untyped("CombineResults", NewCombineResults(WithTrace(traceOut))),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				result = value.(string)
+			}
+			return nil
+		},
+	)
+	if err != nil && !errors.Is(err, ErrIncomplete) {
+		return err
+	}
+
+	if _, werr := fmt.Fprintln(stdout, result); werr != nil {
+		return werr
+	}
+	return err
+}
+
+// runWorker serves a stage to the pipelines running it remotely until ctx is done
+func runWorker(ctx context.Context, args []string) error {
+
+	flags := flag.NewFlagSet("worker", flag.ContinueOnError)
+	flags.SetOutput(io.Discard)
+
+	name := flags.String("stage", "", "the stage to run: SingleHash or MultiHash")
+	listen := flags.String("listen", "", "address to listen on, such as tcp://:7001 or unix:///tmp/signer.sock")
+	salt := flags.String("salt", "", "salt appended to the data before signing")
+	workers := flags.Int("workers", defaultWorkers, "number of values signed at once")
+	maxWorkers := flags.Int("max-workers", 0, "let the pool grow up to N workers while its input backs up")
+
+	if err := flags.Parse(args); err != nil {
+		return fmt.Errorf("%s: %v", workerUsage, err)
+	}
+
+	var s stage
+	switch *name {
+	case "SingleHash":
+		s = Untyped("SingleHash", NewSingleHash(WithAutoscale(*workers, *maxWorkers)))
+	case "MultiHash":
+		s = Untyped("MultiHash", NewMultiHash(WithAutoscale(*workers, *maxWorkers)))
+	default:
+		return errors.New(workerUsage)
+	}
+
+	network, addr, err := ParseAddress(*listen)
+	if err != nil {
+		return err
+	}
+
+	l, err := net.Listen(network, addr)
+	if err != nil {
+		return err
+	}
+
+	DataSignerSalt = *salt
+
+	return ServeStage(ctx, l, s)
+}
+
+// lines is a pipeline source sending the numbers read from r one per line, blank lines are skipped
+func lines(r io.Reader) stage {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		scanner := bufio.NewScanner(r)
+		for line := 1; scanner.Scan(); line++ {
+			text := strings.TrimSpace(scanner.Text())
+			if text == "" {
+				continue
+			}
+
+			number, err := strconv.Atoi(text)
+			if err != nil {
+				return fmt.Errorf("line %d: %q is not a number", line, text)
+			}
+
+			if err := send[interface{}](ctx, out, number); err != nil {
+				return err
+			}
+		}
+		return scanner.Err()
+	}
+}
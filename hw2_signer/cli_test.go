@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunSigner(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	stdout, stderr := &bytes.Buffer{}, &bytes.Buffer{}
+	err := runSigner(strings.NewReader("0\n\n1\n"), stdout, stderr, []string{"--trace", "--single-workers", "2", "--max-workers", "4"})
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542\n"
+	if stdout.String() != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", stdout.String(), expected)
+	}
+
+	for _, step := range []string{
+		"0 SingleHash data 0\n0 SingleHash md5(data) cfcd208495d565ef66e7dff9f98764da\n",
+		"1 SingleHash result 2212294583~709660146\n",
+		"4108050209~502633748 MultiHash: crc32(th+step1)) 5 1025356555\n",
+		"CombineResults " + expected,
+	} {
+		if !strings.Contains(stderr.String(), step) {
+			t.Errorf("step is not traced: %q", step)
+		}
+	}
+}
+
+func TestRunSignerFile(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+	t.Cleanup(func() { DataSignerSalt = "" })
+
+	path := filepath.Join(t.TempDir(), "input")
+	if err := os.WriteFile(path, []byte("0\n1\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	unsalted := &bytes.Buffer{}
+	if err := runSigner(nil, unsalted, &bytes.Buffer{}, []string{path}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stdout := &bytes.Buffer{}
+	if err := runSigner(nil, stdout, &bytes.Buffer{}, []string{"--salt", "pepper", path}); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if DataSignerSalt != "pepper" || strings.Count(stdout.String(), "_") != 1 {
+		t.Errorf("wrong result %q with salt %q", stdout.String(), DataSignerSalt)
+	}
+	if stdout.String() == unsalted.String() {
+		t.Errorf("the salt does not change the result %q", stdout.String())
+	}
+
+	if err := runSigner(strings.NewReader("zero\n"), &bytes.Buffer{}, &bytes.Buffer{}, nil); err == nil || err.Error() != `line 1: "zero" is not a number` {
+		t.Errorf("wrong error: %v", err)
+	}
+
+	if err := runSigner(nil, &bytes.Buffer{}, &bytes.Buffer{}, []string{"a", "b"}); err == nil || err.Error() != usage {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, usage)
+	}
+}
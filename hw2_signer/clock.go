@@ -0,0 +1,180 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Clock is the source of time for the pipelines and the signers
+type Clock interface {
+	Now() time.Time
+	Sleep(d time.Duration)
+	// AfterFunc calls f in its own goroutine once d has passed, stop cancels the call if it has not happened yet
+	AfterFunc(d time.Duration, f func()) (stop func() bool)
+}
+
+type systemClock struct{}
+
+func (systemClock) Now() time.Time        { return time.Now() }
+func (systemClock) Sleep(d time.Duration) { time.Sleep(d) }
+func (systemClock) AfterFunc(d time.Duration, f func()) func() bool {
+	return time.AfterFunc(d, f).Stop
+}
+
+// SystemClock is the real time
+var SystemClock Clock = systemClock{}
+
+type clockKey struct{}
+
+// signers holds the clock timing DataSignerCrc32, DataSignerMd5 and the overheat of DataSignerMd5
+var signers atomic.Value
+
+// heldClock keeps the type stored in signers the same whatever the clock is
+type heldClock struct {
+	Clock
+}
+
+// UseSignersClock makes the signers of common.go wait by the clock, nil restores the system clock.
+// It returns the clock used so far, so a test can put it back.
+func UseSignersClock(clock Clock) (previous Clock) {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return signers.Swap(heldClock{clock}).(heldClock).Clock
+}
+
+func signersClock() Clock {
+	return signers.Load().(heldClock).Clock
+}
+
+func init() {
+	signers.Store(heldClock{SystemClock})
+}
+
+// UseClock makes the pipeline running with ctx measure and wait by the clock
+func UseClock(ctx context.Context, clock Clock) context.Context {
+	return context.WithValue(ctx, clockKey{}, clock)
+}
+
+// clockFrom returns the clock of ctx, the system clock by default
+func clockFrom(ctx context.Context) Clock {
+	if clock, ok := ctx.Value(clockKey{}).(Clock); ok {
+		return clock
+	}
+	return SystemClock
+}
+
+// sleepContext waits for d unless ctx is done first
+func sleepContext(ctx context.Context, clock Clock, d time.Duration) error {
+	wake := make(chan struct{})
+	stop := clock.AfterFunc(d, func() { close(wake) })
+	select {
+	case <-wake:
+		return nil
+	case <-ctx.Done():
+		stop()
+		return ctx.Err()
+	}
+}
+
+// FakeClock is a Clock whose time moves only when it is advanced, it is safe for concurrent use
+type FakeClock struct {
+	mu     sync.Mutex
+	now    time.Time
+	timers []*fakeTimer
+	nextID int64
+}
+
+type fakeTimer struct {
+	id int64
+	at time.Time
+	f  func()
+}
+
+// NewFakeClock creates a fake clock showing start
+func NewFakeClock(start time.Time) *FakeClock {
+	return &FakeClock{now: start}
+}
+
+// Now returns the virtual time
+func (c *FakeClock) Now() time.Time {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.now
+}
+
+// Sleep blocks until the clock is advanced by d
+func (c *FakeClock) Sleep(d time.Duration) {
+	wake := make(chan struct{})
+	c.AfterFunc(d, func() { close(wake) })
+	<-wake
+}
+
+// AfterFunc calls f once the clock is advanced by d
+func (c *FakeClock) AfterFunc(d time.Duration, f func()) func() bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.nextID++
+	t := &fakeTimer{id: c.nextID, at: c.now.Add(d), f: f}
+
+	if d <= 0 {
+		go f()
+		return func() bool { return false }
+	}
+
+	c.timers = append(c.timers, t)
+	sort.SliceStable(c.timers, func(i, j int) bool { return c.timers[i].at.Before(c.timers[j].at) })
+
+	return func() bool {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+
+		for i, pending := range c.timers {
+			if pending.id == t.id {
+				c.timers = append(c.timers[:i], c.timers[i+1:]...)
+				return true
+			}
+		}
+		return false
+	}
+}
+
+// Advance moves the time forward by d firing the timers due on the way
+func (c *FakeClock) Advance(d time.Duration) {
+	c.mu.Lock()
+	c.advanceTo(c.now.Add(d))
+	c.mu.Unlock()
+}
+
+// advanceTo moves the time to target, it is called with the mutex held
+func (c *FakeClock) advanceTo(target time.Time) {
+	for len(c.timers) != 0 && !c.timers[0].at.After(target) {
+		t := c.timers[0]
+		c.timers = c.timers[1:]
+		c.now = t.at
+		go t.f()
+	}
+	if target.After(c.now) {
+		c.now = target
+	}
+}
+
+// Waiters returns how many timers and sleepers wait for the clock
+func (c *FakeClock) Waiters() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return len(c.timers)
+}
+
+// BlockUntil waits until n timers or sleepers wait for the clock
+func (c *FakeClock) BlockUntil(n int) {
+	for c.Waiters() < n {
+		time.Sleep(time.Millisecond)
+	}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+// useFakeClock makes the signers wait by a fake clock and restores their clock after the test
+func useFakeClock(t *testing.T) *FakeClock {
+	clock := NewFakeClock(time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC))
+	previous := UseSignersClock(clock)
+	t.Cleanup(func() { UseSignersClock(previous) })
+	return clock
+}
+
+func TestSignerVirtualTime(t *testing.T) {
+
+	clock := useFakeClock(t)
+	start := clock.Now()
+
+	inputData := []int{0, 1, 1, 2, 3, 5, 8}
+
+	var result string
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		ExecutePipeline(
+			job(func(in, out chan interface{}) {
+				for _, fibNum := range inputData {
+					out <- fibNum
+				}
+			}),
+			job(SingleHash),
+			job(MultiHash),
+			job(CombineResults),
+			job(func(in, out chan interface{}) {
+				result = (<-in).(string)
+			}),
+		)
+	}()
+
+	// the signers sleep in steps of 10ms, the clock moves a step at a time while any of them waits
+	for running := true; running; {
+		select {
+		case <-done:
+			running = false
+		case <-time.After(time.Millisecond):
+			if clock.Waiters() > 0 {
+				clock.Advance(10 * time.Millisecond)
+			}
+		}
+	}
+
+	expected := "1173136728138862632818075107442090076184424490584241521304_1696913515191343735512658979631549563179965036907783101867_27225454331033649287118297354036464389062965355426795162684_29568666068035183841425683795340791879727309630931025356555_3994492081516972096677631278379039212655368881548151736_4958044192186797981418233587017209679042592862002427381542_4958044192186797981418233587017209679042592862002427381542"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	// the md5 of the values run one by one, the crc32 of all of them at once
+	if elapsed := clock.Now().Sub(start); elapsed > 3*time.Second {
+		t.Errorf("execution too long\nGot: %s\nExpected: <%s", elapsed, 3*time.Second)
+	}
+}
+
+func TestSignerOverheat(t *testing.T) {
+
+	clock := useFakeClock(t)
+	start := clock.Now()
+
+	results := make([]string, 2)
+	sign := func(i int, data string) chan struct{} {
+		done := make(chan struct{})
+		go func() {
+			defer close(done)
+			results[i] = DataSignerMd5(data)
+		}()
+		return done
+	}
+
+	// the second call finds the signer busy and waits for a second
+	first := sign(0, "0")
+	clock.BlockUntil(1)
+	second := sign(1, "1")
+	clock.BlockUntil(2)
+
+	clock.Advance(10 * time.Millisecond)
+	<-first
+	clock.Advance(990 * time.Millisecond)
+	clock.BlockUntil(1)
+	clock.Advance(10 * time.Millisecond)
+	<-second
+
+	if results[0] != "cfcd208495d565ef66e7dff9f98764da" || results[1] != "c4ca4238a0b923820dcc509a6f75849b" {
+		t.Errorf("wrong results: %v", results)
+	}
+
+	if elapsed := clock.Now().Sub(start); elapsed != 1010*time.Millisecond {
+		t.Errorf("wrong overheat time\nGot: %s\nExpected: %s", elapsed, 1010*time.Millisecond)
+	}
+}
+
+func TestGuardVirtualRate(t *testing.T) {
+
+	clock := NewFakeClock(time.Time{})
+	guarded := NewGuard(0, 10, clock).Wrap(func(data string) string { return data })
+
+	done := make(chan error, 1)
+	go func() {
+		for i := 0; i < 3; i++ {
+			if _, err := guarded(context.Background(), "x"); err != nil {
+				done <- err
+				return
+			}
+		}
+		done <- nil
+	}()
+
+	// the first call starts at once, the other two wait for the rate
+	for i := 0; i < 2; i++ {
+		clock.BlockUntil(1)
+		clock.Advance(100 * time.Millisecond)
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if elapsed := clock.Now().Sub(time.Time{}); elapsed != 200*time.Millisecond {
+		t.Errorf("wrong rate\nGot: %s\nExpected: %s", elapsed, 200*time.Millisecond)
+	}
+}
@@ -0,0 +1,389 @@
+package main
+
+import (
+	"bufio"
+	"container/heap"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultMemoryBudget is how many bytes of hashes CombineResults keeps in memory before spilling them to disk
+const defaultMemoryBudget = 64 << 20
+
+// minMemoryBudget is the least budget of a combiner, a smaller one would spill a file for every few hashes
+const minMemoryBudget = 1 << 10
+
+// stringOverhead is what a string costs in memory besides its bytes
+const stringOverhead = 16
+
+// maxMergeFanIn is how many runs are merged at once, more runs are merged in passes
+// so that the open files stay within the limits of the process
+const maxMergeFanIn = 64
+
+// Combiner sorts any number of hashes and joins them with _.
+// Once the hashes exceed the memory budget they are sorted and spilled to a temporary file,
+// the files are merged when the result is written.
+type Combiner struct {
+	budget int
+	dir    string
+	fanIn  int
+	size   int
+	hashes []string
+	runs   []string // the files of the spilled runs
+}
+
+// NewCombiner creates a combiner keeping at most budget bytes in memory and spilling to temporary files in dir,
+// the default temporary directory is used for an empty dir. A budget below minMemoryBudget is raised to it.
+func NewCombiner(budget int, dir string) *Combiner {
+	if budget < minMemoryBudget {
+		budget = minMemoryBudget
+	}
+	return &Combiner{budget: budget, dir: dir, fanIn: maxMergeFanIn}
+}
+
+// Add adds a hash to the result
+func (c *Combiner) Add(hash string) error {
+	c.hashes = append(c.hashes, hash)
+	c.size += len(hash) + stringOverhead
+
+	if c.size > c.budget {
+		return c.spill()
+	}
+	return nil
+}
+
+// Runs returns how many sorted runs were spilled to disk
+func (c *Combiner) Runs() int {
+	return len(c.runs)
+}
+
+// spill writes the hashes in memory to a temporary file as a sorted run
+func (c *Combiner) spill() error {
+
+	sort.Strings(c.hashes)
+
+	name, err := c.createRun(func(write func(hash string) error) error {
+		for _, hash := range c.hashes {
+			if err := write(hash); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	c.runs = append(c.runs, name)
+
+	c.hashes = c.hashes[:0]
+	c.size = 0
+	return nil
+}
+
+// createRun writes the hashes fill passes to write into a new temporary file and returns its name,
+// the file is closed once it is written
+func (c *Combiner) createRun(fill func(write func(hash string) error) error) (name string, err error) {
+
+	file, err := os.CreateTemp(c.dir, "combine-*.run")
+	if err != nil {
+		return "", fmt.Errorf("combine: %w", err)
+	}
+	defer func() {
+		if closeErr := file.Close(); err == nil && closeErr != nil {
+			err = fmt.Errorf("combine: %w", closeErr)
+		}
+		if err != nil {
+			os.Remove(file.Name())
+		}
+	}()
+
+	w := bufio.NewWriter(file)
+	var length [binary.MaxVarintLen64]byte
+	write := func(hash string) error {
+		n := binary.PutUvarint(length[:], uint64(len(hash)))
+		if _, err := w.Write(length[:n]); err != nil {
+			return fmt.Errorf("combine: %w", err)
+		}
+		if _, err := w.WriteString(hash); err != nil {
+			return fmt.Errorf("combine: %w", err)
+		}
+		return nil
+	}
+
+	if err := fill(write); err != nil {
+		return "", err
+	}
+	if err := w.Flush(); err != nil {
+		return "", fmt.Errorf("combine: %w", err)
+	}
+	return file.Name(), nil
+}
+
+// openRuns opens the spilled runs, close closes the files opened
+func openRuns(names []string) (runs []sortedRun, close func(), err error) {
+
+	var files []*os.File
+	close = func() {
+		for _, file := range files {
+			file.Close()
+		}
+	}
+
+	for _, name := range names {
+		file, err := os.Open(name)
+		if err != nil {
+			close()
+			return nil, nil, fmt.Errorf("combine: %w", err)
+		}
+		files = append(files, file)
+		runs = append(runs, &fileRun{r: bufio.NewReader(file)})
+	}
+	return runs, close, nil
+}
+
+// mergePass merges the spilled runs in groups of fanIn, each group into a single run
+func (c *Combiner) mergePass() error {
+
+	var merged []string
+	for i := 0; i < len(c.runs); i += c.fanIn {
+		end := i + c.fanIn
+		if end > len(c.runs) {
+			end = len(c.runs)
+		}
+		group := c.runs[i:end]
+		if len(group) == 1 {
+			merged = append(merged, group[0])
+			continue
+		}
+
+		runs, closeRuns, err := openRuns(group)
+		if err != nil {
+			return err
+		}
+		name, err := c.createRun(func(write func(hash string) error) error {
+			return mergeRuns(runs, write)
+		})
+		closeRuns()
+		if err != nil {
+			return err
+		}
+
+		for _, old := range group {
+			os.Remove(old)
+		}
+		merged = append(merged, name)
+	}
+
+	c.runs = merged
+	return nil
+}
+
+// WriteTo writes the sorted hashes joined with _ to w
+func (c *Combiner) WriteTo(w io.Writer) (int64, error) {
+
+	// the hashes in memory take a place among the runs merged at last
+	for len(c.runs) >= c.fanIn {
+		if err := c.mergePass(); err != nil {
+			return 0, err
+		}
+	}
+
+	runs, closeRuns, err := openRuns(c.runs)
+	if err != nil {
+		return 0, err
+	}
+	defer closeRuns()
+
+	sort.Strings(c.hashes)
+	if len(c.hashes) != 0 {
+		runs = append(runs, &memoryRun{hashes: c.hashes})
+	}
+
+	out := bufio.NewWriter(w)
+	written := int64(0)
+
+	first := true
+	err = mergeRuns(runs, func(hash string) error {
+		if !first {
+			if err := out.WriteByte('_'); err != nil {
+				return err
+			}
+			written++
+		}
+		first = false
+
+		n, err := out.WriteString(hash)
+		written += int64(n)
+		return err
+	})
+	if err != nil {
+		return written, err
+	}
+
+	return written, out.Flush()
+}
+
+// mergeRuns passes the hashes of the sorted runs to emit in order
+func mergeRuns(runs []sortedRun, emit func(hash string) error) error {
+
+	// every run is positioned at its first hash
+	cursors := &runHeap{}
+	for _, run := range runs {
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			cursors.items = append(cursors.items, run)
+		}
+	}
+	heap.Init(cursors)
+
+	for cursors.Len() != 0 {
+		run := cursors.items[0]
+		if err := emit(run.current()); err != nil {
+			return err
+		}
+
+		ok, err := run.next()
+		if err != nil {
+			return err
+		}
+		if ok {
+			heap.Fix(cursors, 0)
+		} else {
+			heap.Pop(cursors)
+		}
+	}
+	return nil
+}
+
+// Close removes the temporary files
+func (c *Combiner) Close() error {
+	var first error
+	for _, name := range c.runs {
+		if err := os.Remove(name); err != nil && first == nil {
+			first = err
+		}
+	}
+	c.runs = nil
+	c.hashes = nil
+	c.size = 0
+	return first
+}
+
+// sortedRun is a sorted sequence of hashes read one by one
+type sortedRun interface {
+	next() (bool, error)
+	current() string
+}
+
+type memoryRun struct {
+	hashes []string
+	value  string
+}
+
+func (r *memoryRun) next() (bool, error) {
+	if len(r.hashes) == 0 {
+		return false, nil
+	}
+	r.value, r.hashes = r.hashes[0], r.hashes[1:]
+	return true, nil
+}
+
+func (r *memoryRun) current() string {
+	return r.value
+}
+
+type fileRun struct {
+	r     *bufio.Reader
+	value string
+}
+
+func (r *fileRun) next() (bool, error) {
+	length, err := binary.ReadUvarint(r.r)
+	if err == io.EOF {
+		return false, nil
+	}
+	if err != nil {
+		return false, fmt.Errorf("combine: %w", err)
+	}
+
+	buf := make([]byte, length)
+	if _, err := io.ReadFull(r.r, buf); err != nil {
+		return false, fmt.Errorf("combine: %w", err)
+	}
+	r.value = string(buf)
+	return true, nil
+}
+
+func (r *fileRun) current() string {
+	return r.value
+}
+
+// runHeap orders the runs by their current hashes
+type runHeap struct {
+	items []sortedRun
+}
+
+func (h *runHeap) Len() int           { return len(h.items) }
+func (h *runHeap) Less(i, j int) bool { return h.items[i].current() < h.items[j].current() }
+func (h *runHeap) Swap(i, j int)      { h.items[i], h.items[j] = h.items[j], h.items[i] }
+func (h *runHeap) Push(x interface{}) { h.items = append(h.items, x.(sortedRun)) }
+func (h *runHeap) Pop() interface{} {
+	last := h.items[len(h.items)-1]
+	h.items = h.items[:len(h.items)-1]
+	return last
+}
+
+// combine sorts the hashes from in and writes them joined with _ to w
+func combine(ctx context.Context, c config, in chan string, w io.Writer) error {
+
+	combiner := NewCombiner(c.budget, c.tempDir)
+	defer combiner.Close()
+
+	for {
+		value, ok, err := takeItem(ctx, in)
+		if err != nil {
+			return err
+		}
+		if !ok {
+			break
+		}
+
+		if err := combiner.Add(value); err != nil {
+			return err
+		}
+	}
+
+	_, err := combiner.WriteTo(w)
+	return err
+}
+
+// NewCombineResults builds a CombineResults stage, it accepts WithMemoryBudget, WithTempDir and WithTrace.
+// After a shutdown cut its input it emits the result of what it got and the pipeline returns ErrIncomplete.
+func NewCombineResults(opts ...Option) Stage[string, string] {
+	c := newConfig(config{name: "CombineResults", budget: defaultMemoryBudget}, opts)
+	return func(ctx context.Context, in chan string, out chan string) error {
+		result := &strings.Builder{}
+		if err := combine(ctx, c, in, result); err != nil {
+			return err
+		}
+		trace(c.trace, "CombineResults "+result.String())
+		return passItem(ctx, out, result.String())
+	}
+}
+
+// CombineTo builds a sink streaming the combined result to w instead of building it in memory,
+// it accepts WithMemoryBudget and WithTempDir
+func CombineTo(w io.Writer, opts ...Option) Sink[string] {
+	c := newConfig(config{name: "CombineResults", budget: defaultMemoryBudget}, opts)
+	return func(ctx context.Context, in chan string) error {
+		return combine(ctx, c, in, w)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestCombinerSpill(t *testing.T) {
+
+	dir := t.TempDir()
+	combiner := NewCombiner(minMemoryBudget, dir)
+
+	var hashes []string
+	for i := 0; i < 1000; i++ {
+		hash := strconv.Itoa(i * 7919 % 1000)
+		hashes = append(hashes, hash)
+		if err := combiner.Add(hash); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if combiner.Runs() < 10 {
+		t.Errorf("hashes beyond the budget are not spilled, %d runs", combiner.Runs())
+	}
+
+	result := &bytes.Buffer{}
+	if _, err := combiner.WriteTo(result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Strings(hashes)
+	if expected := strings.Join(hashes, "_"); result.String() != expected {
+		t.Errorf("results not match\nGot: %.100s...\nExpected: %.100s...", result.String(), expected)
+	}
+
+	if err := combiner.Close(); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("temporary files are left: %d", len(files))
+	}
+}
+
+func TestCombinerMinimumBudget(t *testing.T) {
+
+	dir := t.TempDir()
+	combiner := NewCombiner(0, dir)
+	defer combiner.Close()
+
+	// a few hashes fit any budget instead of spilling a run each
+	for i := 0; i < 10; i++ {
+		if err := combiner.Add(strconv.Itoa(i)); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+	if combiner.Runs() != 0 {
+		t.Errorf("hashes within the minimum budget are spilled, %d runs", combiner.Runs())
+	}
+}
+
+func TestCombinerMergePasses(t *testing.T) {
+
+	dir := t.TempDir()
+	combiner := NewCombiner(minMemoryBudget, dir)
+	combiner.fanIn = 3
+
+	var hashes []string
+	for i := 0; i < 2000; i++ {
+		hash := strconv.Itoa(i * 7919 % 2000)
+		hashes = append(hashes, hash)
+		if err := combiner.Add(hash); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	if combiner.Runs() < 3*3*3 {
+		t.Fatalf("too few runs for several passes: %d", combiner.Runs())
+	}
+
+	result := &bytes.Buffer{}
+	if _, err := combiner.WriteTo(result); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Strings(hashes)
+	if expected := strings.Join(hashes, "_"); result.String() != expected {
+		t.Errorf("results not match\nGot: %.100s...\nExpected: %.100s...", result.String(), expected)
+	}
+
+	// the last merge leaves a place among the fan-in for the hashes in memory
+	if combiner.Runs() >= 3 {
+		t.Errorf("runs are not merged in passes: %d left", combiner.Runs())
+	}
+
+	combiner.Close()
+	if files, _ := os.ReadDir(dir); len(files) != 0 {
+		t.Errorf("temporary files are left: %d", len(files))
+	}
+}
+
+func TestCombineTo(t *testing.T) {
+
+	texts := Then(From(numbers(500)), func(ctx context.Context, in chan int, out chan string) error {
+		for value := range in {
+			if err := send(ctx, out, strconv.Itoa(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	result := &bytes.Buffer{}
+	err := texts.Run(context.Background(), CombineTo(result, WithMemoryBudget(256), WithTempDir(t.TempDir())))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	parts := strings.Split(result.String(), "_")
+	if len(parts) != 500 || !sort.StringsAreSorted(parts) || parts[0] != "0" || parts[499] != "99" {
+		t.Errorf("wrong result: %.100s...", result.String())
+	}
+}
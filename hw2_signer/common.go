@@ -24,7 +24,7 @@ var OverheatLock = func() {
 	for {
 		if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 0, 1); !swapped {
 			fmt.Println("OverheatLock happend")
-			time.Sleep(time.Second)
+			signersClock().Sleep(time.Second)
 		} else {
 			break
 		}
@@ -35,7 +35,7 @@ var OverheatUnlock = func() {
 	for {
 		if swapped := atomic.CompareAndSwapUint32(&dataSignerOverheat, 1, 0); !swapped {
 			fmt.Println("OverheatUnlock happend")
-			time.Sleep(time.Second)
+			signersClock().Sleep(time.Second)
 		} else {
 			break
 		}
@@ -47,7 +47,7 @@ var DataSignerMd5 = func(data string) string {
 	defer OverheatUnlock()
 	data += DataSignerSalt
 	dataHash := fmt.Sprintf("%x", md5.Sum([]byte(data)))
-	time.Sleep(10 * time.Millisecond)
+	signersClock().Sleep(10 * time.Millisecond)
 	return dataHash
 }
 
@@ -55,6 +55,6 @@ var DataSignerCrc32 = func(data string) string {
 	data += DataSignerSalt
 	crcH := crc32.ChecksumIEEE([]byte(data))
 	dataHash := strconv.FormatUint(uint64(crcH), 10)
-	time.Sleep(time.Second)
+	signersClock().Sleep(time.Second)
 	return dataHash
 }
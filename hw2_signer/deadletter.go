@@ -0,0 +1,94 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// DeadLetter is an item a stage could not process
+type DeadLetter struct {
+	Stage string
+	Item  interface{}
+	Err   error
+}
+
+// DeadLetters collects the items rejected by stages, the zero value is ready to use
+type DeadLetters struct {
+	mu      sync.Mutex
+	letters []DeadLetter
+}
+
+// Put records a rejected item
+func (d *DeadLetters) Put(letter DeadLetter) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters = append(d.letters, letter)
+}
+
+// Letters returns a copy of the rejected items in the order they were rejected
+func (d *DeadLetters) Letters() []DeadLetter {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return append([]DeadLetter(nil), d.letters...)
+}
+
+// Reset forgets every rejected item
+func (d *DeadLetters) Reset() {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.letters = nil
+}
+
+// DeadLetterSummary counts rejected items
+type DeadLetterSummary struct {
+	Total   int
+	ByStage map[string]int
+}
+
+// Summary counts the rejected items by stage
+func (d *DeadLetters) Summary() DeadLetterSummary {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	summary := DeadLetterSummary{Total: len(d.letters), ByStage: make(map[string]int)}
+	for _, letter := range d.letters {
+		summary.ByStage[letter.Stage]++
+	}
+	return summary
+}
+
+func (s DeadLetterSummary) String() string {
+
+	stages := make([]string, 0, len(s.ByStage))
+	for name, count := range s.ByStage {
+		stages = append(stages, fmt.Sprintf("%s %d", name, count))
+	}
+	sort.Strings(stages)
+
+	if len(stages) == 0 {
+		return "no items rejected"
+	}
+	return fmt.Sprintf("%d items rejected: %s", s.Total, strings.Join(stages, ", "))
+}
+
+type deadLettersKey struct{}
+
+// CollectDeadLetters makes the stages running with ctx put the items they can't process
+// into d and carry on, instead of failing
+func CollectDeadLetters(ctx context.Context, d *DeadLetters) context.Context {
+	return context.WithValue(ctx, deadLettersKey{}, d)
+}
+
+// reject puts the item into the dead letters of ctx,
+// without them it returns the error to fail the stage
+func reject(ctx context.Context, stage string, item interface{}, err error) error {
+	d, ok := ctx.Value(deadLettersKey{}).(*DeadLetters)
+	if !ok || d == nil {
+		return err
+	}
+	d.Put(DeadLetter{Stage: stage, Item: item, Err: err})
+	return nil
+}
@@ -0,0 +1,129 @@
+package main
+
+import (
+	"context"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestExecutePipelineDeadLetters(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	var received uint32
+
+	dead, err := ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 0
+			out <- "not a number"
+			out <- 1
+			out <- 2.5
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&received, 1)
+			}
+		}),
+	)
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received != 2 {
+		t.Errorf("wrong number of results\nGot: %d\nExpected: %d", received, 2)
+	}
+
+	summary := dead.Summary()
+	if summary.Total != 2 || summary.ByStage["SingleHash"] != 2 {
+		t.Errorf("wrong summary\nGot: %v\nExpected: 2 items rejected: SingleHash 2", summary)
+	}
+
+	letters := dead.Letters()
+	if len(letters) != 2 || letters[0].Item != "not a number" || letters[0].Err == nil {
+		t.Errorf("wrong dead letters: %v", letters)
+	}
+}
+
+func TestExecutePipelineWrappedJobs(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	var received uint32
+
+	// a job calling SingleHash is run in the context of the pipeline as well
+	dead, err := ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- "not a number"
+			out <- 1
+		}),
+		func(in, out chan interface{}) {
+			SingleHash(in, out)
+		},
+		job(func(in, out chan interface{}) {
+			for range in {
+				atomic.AddUint32(&received, 1)
+			}
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received != 1 || dead.Summary().ByStage["SingleHash"] != 1 {
+		t.Errorf("wrapped job is not recovered: %d results, %v", received, dead.Summary())
+	}
+
+	// a failing job fails the pipeline instead of crashing it
+	_, err = ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+		}),
+		job(func(in, out chan interface{}) {
+			panic("boom")
+		}),
+	)
+	if err == nil || err.Error() != "panic: boom" {
+		t.Errorf("wrong error\nGot: %v\nExpected: panic: boom", err)
+	}
+}
+
+func TestPoolPanicRecovery(t *testing.T) {
+
+	dead := &DeadLetters{}
+	ctx := CollectDeadLetters(context.Background(), dead)
+
+	fragile := func(ctx context.Context, value int) (int, error) {
+		if value%3 == 0 {
+			panic("multiple of three")
+		}
+		return value, nil
+	}
+
+	var result []int
+	chain := Then(From(numbers(10)), Map(fragile, WithName("fragile"), WithWorkers(3), WithOrder()))
+	if err := chain.Run(ctx, collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := []int{1, 2, 4, 5, 7, 8}
+	if len(result) != len(expected) {
+		t.Fatalf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+	for i := range expected {
+		if result[i] != expected[i] {
+			t.Fatalf("results not match\nGot: %v\nExpected: %v", result, expected)
+		}
+	}
+
+	if summary := dead.Summary().String(); summary != "4 items rejected: fragile 4" {
+		t.Errorf("wrong summary\nGot: %v\nExpected: %v", summary, "4 items rejected: fragile 4")
+	}
+
+	// without dead letters the first bad item fails the stage
+	err := Then(From(numbers(10)), Map(fragile)).Run(context.Background(), collect(&result))
+	if err == nil {
+		t.Errorf("expected an error without dead letters")
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// stuckAfter is how long a stage may wait to hand a value over a direct edge before the edge is drawn stuck
+const stuckAfter = time.Second
+
+func init() {
+	http.Handle("/debug/pipelines", DOTHandler())
+}
+
+// DOT renders the run as a Graphviz graph: the stages with their workers and item totals
+// and the edges with the occupancy of their buffers. A full buffer or a direct edge the previous stage
+// waits on for stuckAfter is drawn red, it is where a stuck pipeline waits.
+func (s MetricsSnapshot) DOT() string {
+	b := &strings.Builder{}
+	fmt.Fprintf(b, "digraph %q {\n", "pipeline "+strconv.FormatInt(s.ID, 10))
+	fmt.Fprintf(b, "\tlabel=%q;\n", s.title())
+	s.writeDOT(b, "\t", "")
+	b.WriteString("}\n")
+	return b.String()
+}
+
+// title describes the run in the graph label
+func (s MetricsSnapshot) title() string {
+	if s.Running {
+		return fmt.Sprintf("pipeline %d, running", s.ID)
+	}
+	return fmt.Sprintf("pipeline %d, finished in %s", s.ID, s.Finished.Sub(s.Started))
+}
+
+// writeDOT writes the nodes and the edges of the run, the nodes are identified by the stage IDs
+// as the names may repeat, prefix keeps the nodes of different runs apart
+func (s MetricsSnapshot) writeDOT(w io.Writer, indent, prefix string) {
+
+	fmt.Fprintf(w, "%snode [shape=box];\n", indent)
+
+	for _, stage := range s.Stages {
+		label := stage.Name
+		if stage.Workers > 0 {
+			label += fmt.Sprintf("\nworkers %d, busy %d", stage.Workers, stage.Busy)
+		}
+		label += fmt.Sprintf("\nin %d, out %d", stage.ItemsIn, stage.ItemsOut)
+
+		fmt.Fprintf(w, "%s%q [label=%q];\n", indent, prefix+strconv.Itoa(stage.ID), label)
+	}
+
+	for _, edge := range s.Edges {
+		// a direct edge holds nothing, it is stuck while the previous stage waits to hand a value over
+		label := "direct"
+		stuck := edge.Waiting >= stuckAfter
+		if edge.Capacity > 0 {
+			label = fmt.Sprintf("%d/%d", edge.Depth, edge.Capacity)
+			stuck = edge.Depth >= int64(edge.Capacity)
+		} else if edge.Waiting > 0 {
+			label += fmt.Sprintf("\nwaiting %s", edge.Waiting.Round(time.Millisecond))
+		}
+		if edge.Dropped > 0 {
+			label += fmt.Sprintf("\ndropped %d", edge.Dropped)
+		}
+
+		attrs := fmt.Sprintf("label=%q", label)
+		if stuck {
+			attrs += ", color=red, fontcolor=red, penwidth=2"
+		}
+
+		fmt.Fprintf(w, "%s%q -> %q [%s];\n", indent, prefix+strconv.Itoa(edge.FromID), prefix+strconv.Itoa(edge.ToID), attrs)
+	}
+}
+
+// DOTHandler serves the runs of Pipelines as a Graphviz graph, one cluster per run.
+// The id query parameter selects a single run. The handler is registered at /debug/pipelines.
+func DOTHandler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+
+		runs := Pipelines()
+
+		if id := r.URL.Query().Get("id"); id != "" {
+			for _, run := range runs {
+				if strconv.FormatInt(run.ID, 10) == id {
+					w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+					io.WriteString(w, run.DOT())
+					return
+				}
+			}
+			http.Error(w, "no pipeline "+id, http.StatusNotFound)
+			return
+		}
+
+		w.Header().Set("Content-Type", "text/vnd.graphviz; charset=utf-8")
+		io.WriteString(w, "digraph pipelines {\n")
+		for _, run := range runs {
+			fmt.Fprintf(w, "\tsubgraph cluster_%d {\n", run.ID)
+			fmt.Fprintf(w, "\t\tlabel=%q;\n", run.title())
+			run.writeDOT(w, "\t\t", strconv.FormatInt(run.ID, 10)+"/")
+			io.WriteString(w, "\t}\n")
+		}
+		io.WriteString(w, "}\n")
+	})
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"context"
+	"io"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDOTStuckEdge(t *testing.T) {
+
+	release := make(chan struct{})
+	sink := func(ctx context.Context, in chan int) error {
+		<-release
+		return collect(new([]int))(ctx, in)
+	}
+
+	m := &Metrics{}
+	ctx := RecordMetrics(context.Background(), m)
+
+	errc := make(chan error)
+	go func() {
+		errc <- From(numbers(10)).Run(ctx, sink, EdgeBuffer(2))
+	}()
+
+	// the sink does not read, so the source fills the buffer and waits
+	for m.Snapshot().Edges == nil || m.Snapshot().Edges[0].Depth != 2 {
+		time.Sleep(time.Millisecond)
+	}
+
+	dot := m.Snapshot().DOT()
+	for _, expected := range []string{
+		"label=\"pipeline " + strconv.FormatInt(m.Snapshot().ID, 10) + ", running\"",
+		"\"1\" [label=\"TestDOTStuckEdge.func1\\nin 0, out 0\"]",
+		"label=\"2/2\", color=red",
+	} {
+		if !strings.Contains(dot, expected) {
+			t.Errorf("no %s in\n%s", expected, dot)
+		}
+	}
+
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dot := m.Snapshot().DOT(); strings.Contains(dot, "red") || !strings.Contains(dot, "label=\"0/2\"") {
+		t.Errorf("finished pipeline has a stuck edge\n%s", dot)
+	}
+}
+
+func TestDOTStuckDirectEdge(t *testing.T) {
+
+	release := make(chan struct{})
+	sink := func(ctx context.Context, in chan int) error {
+		<-release
+		return collect(new([]int))(ctx, in)
+	}
+
+	m := &Metrics{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(RecordMetrics(context.Background(), m), clock)
+
+	errc := make(chan error)
+	go func() {
+		errc <- From(numbers(10)).Run(ctx, sink)
+	}()
+
+	// the sink does not read, so the source waits on the edge until the time passes
+	for m.Snapshot().Edges == nil || m.Snapshot().Edges[0].Waiting < stuckAfter {
+		clock.Advance(stuckAfter)
+		time.Sleep(time.Millisecond)
+	}
+
+	dot := m.Snapshot().DOT()
+	if !strings.Contains(dot, "label=\"direct\\nwaiting ") || !strings.Contains(dot, "color=red") {
+		t.Errorf("no stuck direct edge in\n%s", dot)
+	}
+
+	close(release)
+	if err := <-errc; err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if dot := m.Snapshot().DOT(); strings.Contains(dot, "red") || !strings.Contains(dot, "label=\"direct\"") {
+		t.Errorf("finished pipeline has a stuck edge\n%s", dot)
+	}
+}
+
+func TestDOTHandler(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+			out <- 2
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			<-in
+		}),
+	)
+
+	runs := Pipelines()
+	id := strconv.FormatInt(runs[len(runs)-1].ID, 10)
+
+	get := func(url string) (int, string) {
+		w := httptest.NewRecorder()
+		DOTHandler().ServeHTTP(w, httptest.NewRequest("GET", url, nil))
+		body, _ := io.ReadAll(w.Result().Body)
+		return w.Code, string(body)
+	}
+
+	code, body := get("/debug/pipelines")
+	if code != 200 || !strings.Contains(body, "subgraph cluster_"+id) || !strings.Contains(body, "\""+id+"/1\" -> \""+id+"/2\"") {
+		t.Errorf("run %s is not in the graph of all runs\n%s", id, body)
+	}
+
+	code, body = get("/debug/pipelines?id=" + id)
+	if code != 200 || !strings.HasPrefix(body, "digraph \"pipeline "+id+"\"") || !strings.Contains(body, "\"1\" [label=\"SingleHash\\nin 2, out 2\"]") || !strings.Contains(body, "\"1\" -> \"2\" [label=\"direct\"]") {
+		t.Errorf("wrong graph of run %s\n%s", id, body)
+	}
+
+	if code, _ = get("/debug/pipelines?id=0"); code != 404 {
+		t.Errorf("wrong status of a missing run\nGot: %d\nExpected: %d", code, 404)
+	}
+}
@@ -0,0 +1,248 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync/atomic"
+	"time"
+)
+
+// Overflow is what an edge does with a value arriving when its buffer is full
+type Overflow int
+
+const (
+	// Block makes the previous stage wait for room in the buffer
+	Block Overflow = iota
+	// DropOldest discards the value that waited the longest to make room for the new one
+	DropOldest
+	// DropNewest discards the arriving value
+	DropNewest
+	// Fail fails the pipeline with ErrOverflow
+	Fail
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case Block:
+		return "block"
+	case DropOldest:
+		return "drop-oldest"
+	case DropNewest:
+		return "drop-newest"
+	case Fail:
+		return "fail"
+	}
+	return fmt.Sprintf("Overflow(%d)", int(o))
+}
+
+// ErrOverflow is returned by a pipeline when an edge with the Fail policy overflows
+var ErrOverflow = errors.New("edge buffer overflow")
+
+// EdgeOption configures the channel between two stages
+type EdgeOption func(*edgeConfig)
+
+type edgeConfig struct {
+	buffer   int
+	overflow Overflow
+}
+
+// EdgeBuffer sets how many values the edge holds for the next stage. Zero, the default, hands the values
+// over directly as an unbuffered channel does, the stages of a chain measure such an edge on their ends.
+// A buffered edge is passed through by a goroutine measuring it.
+func EdgeBuffer(n int) EdgeOption {
+	return func(c *edgeConfig) {
+		c.buffer = n
+	}
+}
+
+// EdgeOverflow sets what the edge does when its buffer is full, Block is the default.
+// The other policies need a buffer, the edge holds at least one value with them.
+func EdgeOverflow(overflow Overflow) EdgeOption {
+	return func(c *edgeConfig) {
+		c.overflow = overflow
+	}
+}
+
+func newEdgeConfig(opts []EdgeOption) edgeConfig {
+	c := edgeConfig{}
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.buffer < 0 || c.overflow != Block && c.buffer < 1 {
+		c.buffer = 1
+	}
+	return c
+}
+
+// EdgeMetrics is the state of the channel between two stages
+type EdgeMetrics struct {
+	depth    int64
+	maxDepth int64
+	dropped  int64
+	items    int64
+
+	from, to *StageMetrics
+	config   edgeConfig
+}
+
+// EdgeSnapshot is a copy of the metrics of an edge
+type EdgeSnapshot struct {
+	From     string
+	To       string
+	FromID   int // the IDs of the stages the edge connects
+	ToID     int
+	Capacity int
+	Overflow string
+	Depth    int64 // the values waiting in the buffer
+	MaxDepth int64
+	Dropped  int64
+	Items    int64         // the values passed to the next stage
+	Waiting  time.Duration // how long the previous stage waits to hand a value over an edge without a buffer
+	Starving time.Duration // how long the next stage waits for a value over an edge without a buffer
+}
+
+// addEdge registers an edge of the run
+func (m *Metrics) addEdge(from, to *StageMetrics, c edgeConfig) *EdgeMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e := &EdgeMetrics{from: from, to: to, config: c}
+	m.edges = append(m.edges, e)
+	return e
+}
+
+// snapshot copies the metrics of the edge. The items and the waits over a chain edge without a buffer
+// are the ones its stages measured on their ends at now.
+func (e *EdgeMetrics) snapshot(now time.Time) EdgeSnapshot {
+	snapshot := EdgeSnapshot{
+		From:     e.from.stageName(),
+		To:       e.to.stageName(),
+		FromID:   e.from.id,
+		ToID:     e.to.id,
+		Capacity: e.config.buffer,
+		Overflow: e.config.overflow.String(),
+		Depth:    atomic.LoadInt64(&e.depth),
+		MaxDepth: atomic.LoadInt64(&e.maxDepth),
+		Dropped:  atomic.LoadInt64(&e.dropped),
+		Items:    atomic.LoadInt64(&e.items),
+	}
+	if e.config.buffer == 0 && e.from.directOut && e.to.directIn {
+		// the previous stage counts a value once it is taken, the next one may not have counted it yet
+		snapshot.Items = atomic.LoadInt64(&e.from.itemsOut)
+		if taken := atomic.LoadInt64(&e.to.itemsIn); taken > snapshot.Items {
+			snapshot.Items = taken
+		}
+		_, snapshot.Waiting = e.from.sending.waited(now)
+		_, snapshot.Starving = e.to.receiving.waited(now)
+	}
+	return snapshot
+}
+
+func (e *EdgeMetrics) setDepth(depth int) {
+	atomic.StoreInt64(&e.depth, int64(depth))
+	for {
+		max := atomic.LoadInt64(&e.maxDepth)
+		if int64(depth) <= max || atomic.CompareAndSwapInt64(&e.maxDepth, max, int64(depth)) {
+			return
+		}
+	}
+}
+
+// connect passes the values from src to the returned channel through the buffer of the edge
+// and accounts them for the stages on both of its ends, either of which may be nil.
+// An edge without a buffer returns src itself.
+func connect[T any](g *group, from, to *StageMetrics, e *EdgeMetrics, src chan T) chan T {
+
+	c := e.config
+	if c.buffer == 0 {
+		return src
+	}
+
+	dst := make(chan T)
+
+	g.Go(func(ctx context.Context) error {
+		defer close(dst)
+
+		clock := clockFrom(ctx)
+
+		var queue []T
+		closed := false
+		emptySince := clock.Now() // the next stage is starving while the buffer is empty
+		var fullSince time.Time   // the previous stage is blocked while the buffer is full
+
+		for {
+			if closed && len(queue) == 0 {
+				return nil
+			}
+
+			// a blocking edge stops taking values while the buffer is full
+			var input chan T
+			if !closed && (c.overflow != Block || len(queue) < c.buffer) {
+				input = src
+			}
+
+			var output chan T
+			var head T
+			if len(queue) != 0 {
+				output, head = dst, queue[0]
+			}
+
+			select {
+			case value, ok := <-input:
+				if !ok {
+					closed = true
+					continue
+				}
+
+				now := clock.Now()
+				from.sent()
+				if len(queue) == 0 {
+					to.starved(now.Sub(emptySince))
+				}
+
+				if len(queue) < c.buffer {
+					queue = append(queue, value)
+				} else {
+					atomic.AddInt64(&e.dropped, 1)
+
+					switch c.overflow {
+					case DropOldest:
+						queue = append(queue[1:], value)
+					case Fail:
+						err := fmt.Errorf("%w between %s and %s", ErrOverflow, e.from.stageName(), e.to.stageName())
+						g.fail(err)
+						drain(src)
+						return err
+					}
+				}
+
+				if c.overflow == Block && len(queue) == c.buffer {
+					fullSince = now
+				}
+				e.setDepth(len(queue))
+
+			case output <- head:
+				now := clock.Now()
+				if c.overflow == Block && len(queue) == c.buffer && !closed {
+					from.stalled(now.Sub(fullSince))
+				}
+
+				queue = queue[1:]
+				if len(queue) == 0 {
+					emptySince = now
+				}
+
+				atomic.AddInt64(&e.items, 1)
+				e.setDepth(len(queue))
+				to.received()
+
+			case <-ctx.Done():
+				drain(src)
+				return nil
+			}
+		}
+	})
+
+	return dst
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+)
+
+// burst runs ten numbers through an edge whose sink only starts reading after the source is done
+func burst(opts ...EdgeOption) ([]int, EdgeSnapshot, error) {
+
+	done := make(chan struct{})
+	source := func(ctx context.Context, out chan int) error {
+		defer close(done)
+		return numbers(10)(ctx, out)
+	}
+
+	var result []int
+	sink := func(ctx context.Context, in chan int) error {
+		select {
+		case <-done:
+		case <-ctx.Done():
+			return nil
+		}
+		return collect(&result)(ctx, in)
+	}
+
+	m := &Metrics{}
+	err := From(source).Run(RecordMetrics(context.Background(), m), sink, opts...)
+
+	var edge EdgeSnapshot
+	if edges := m.Snapshot().Edges; len(edges) == 1 {
+		edge = edges[0]
+	}
+	return result, edge, err
+}
+
+func TestEdgeOverflow(t *testing.T) {
+
+	cases := []struct {
+		overflow Overflow
+		expected string
+		dropped  int64
+	}{
+		{DropNewest, "[0 1 2]", 7},
+		{DropOldest, "[7 8 9]", 7},
+	}
+
+	for _, c := range cases {
+		result, edge, err := burst(EdgeBuffer(3), EdgeOverflow(c.overflow))
+		if err != nil {
+			t.Fatalf("%s: unexpected error: %v", c.overflow, err)
+		}
+
+		if got := fmt.Sprint(result); got != c.expected {
+			t.Errorf("%s: results not match\nGot: %v\nExpected: %v", c.overflow, got, c.expected)
+		}
+
+		if edge.Dropped != c.dropped || edge.MaxDepth != 3 || edge.Items != 3 || edge.Depth != 0 {
+			t.Errorf("%s: wrong edge counters: %+v", c.overflow, edge)
+		}
+	}
+}
+
+func TestEdgeOverflowFail(t *testing.T) {
+
+	_, edge, err := burst(EdgeBuffer(3), EdgeOverflow(Fail))
+	if !errors.Is(err, ErrOverflow) {
+		t.Fatalf("wrong error\nGot: %v\nExpected: %v", err, ErrOverflow)
+	}
+
+	if edge.Overflow != "fail" || edge.Dropped != 1 {
+		t.Errorf("wrong edge counters: %+v", edge)
+	}
+}
+
+func TestEdgeBuffer(t *testing.T) {
+
+	// the source runs ahead of the sink as far as the buffer allows
+	result, edge, err := burst(EdgeBuffer(10))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 10 {
+		t.Errorf("wrong number of results\nGot: %d\nExpected: %d", len(result), 10)
+	}
+
+	if edge.Capacity != 10 || edge.MaxDepth != 10 || edge.Dropped != 0 || edge.Overflow != "block" {
+		t.Errorf("wrong edge counters: %+v", edge)
+	}
+}
@@ -0,0 +1,352 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// Fanout is how a stage with several downstream stages distributes its output
+type Fanout int
+
+const (
+	// Broadcast sends every value to each downstream stage
+	Broadcast Fanout = iota
+	// RoundRobin sends every value to one downstream stage, taking them in turn
+	RoundRobin
+)
+
+// Graph is a pipeline whose stages form a directed acyclic graph. A stage may feed
+// several stages and several stages may feed one, their outputs are merged.
+type Graph struct {
+	nodes map[string]*graphNode
+	order []string // the nodes in the order they were added
+	edges map[[2]string]edgeConfig
+	err   error
+}
+
+type nodeKind int
+
+const (
+	sourceNode nodeKind = iota
+	stageNode
+	sinkNode
+)
+
+type graphNode struct {
+	name    string
+	kind    nodeKind
+	run     stage // sources get a nil input, sinks get a nil output
+	fanout  Fanout
+	inputs  []string
+	outputs []string
+}
+
+// NewGraph creates an empty graph
+func NewGraph() *Graph {
+	return &Graph{nodes: make(map[string]*graphNode), edges: make(map[[2]string]edgeConfig)}
+}
+
+// AddSource adds a stage without input
+func (g *Graph) AddSource(name string, source Source[interface{}]) *Graph {
+	return g.add(name, sourceNode, func(ctx context.Context, in, out chan interface{}) error {
+		return stoppable(source)(ctx, out)
+	})
+}
+
+// AddStage adds a stage with input and output
+func (g *Graph) AddStage(name string, s Stage[interface{}, interface{}]) *Graph {
+	return g.add(name, stageNode, s)
+}
+
+// AddSink adds a stage without output
+func (g *Graph) AddSink(name string, sink Sink[interface{}]) *Graph {
+	return g.add(name, sinkNode, func(ctx context.Context, in, out chan interface{}) error {
+		return sink(ctx, in)
+	})
+}
+
+func (g *Graph) add(name string, kind nodeKind, run stage) *Graph {
+	if _, ok := g.nodes[name]; ok {
+		g.fail(fmt.Errorf("graph: duplicate stage %s", name))
+		return g
+	}
+	g.nodes[name] = &graphNode{name: name, kind: kind, run: run}
+	g.order = append(g.order, name)
+	return g
+}
+
+// Connect feeds the output of one stage to another, the options configure the edge between them
+func (g *Graph) Connect(from, to string, opts ...EdgeOption) *Graph {
+	source, ok := g.nodes[from]
+	if !ok {
+		g.fail(fmt.Errorf("graph: unknown stage %s", from))
+		return g
+	}
+	target, ok := g.nodes[to]
+	if !ok {
+		g.fail(fmt.Errorf("graph: unknown stage %s", to))
+		return g
+	}
+	for _, next := range source.outputs {
+		if next == to {
+			g.fail(fmt.Errorf("graph: duplicate connection %s -> %s", from, to))
+			return g
+		}
+	}
+	source.outputs = append(source.outputs, to)
+	target.inputs = append(target.inputs, from)
+	g.edges[[2]string{from, to}] = newEdgeConfig(opts)
+	return g
+}
+
+// SetFanout sets how the stage distributes its output, Broadcast is the default
+func (g *Graph) SetFanout(name string, fanout Fanout) *Graph {
+	node, ok := g.nodes[name]
+	if !ok {
+		g.fail(fmt.Errorf("graph: unknown stage %s", name))
+		return g
+	}
+	node.fanout = fanout
+	return g
+}
+
+func (g *Graph) fail(err error) {
+	if g.err == nil {
+		g.err = err
+	}
+}
+
+// Validate checks that every stage is properly connected and that there are no cycles
+func (g *Graph) Validate() error {
+	_, err := g.sorted()
+	return err
+}
+
+// sorted returns the nodes in topological order
+func (g *Graph) sorted() ([]*graphNode, error) {
+
+	if g.err != nil {
+		return nil, g.err
+	}
+
+	if len(g.nodes) == 0 {
+		return nil, fmt.Errorf("graph: no stages")
+	}
+
+	// checking the connections
+	for _, name := range g.order {
+		node := g.nodes[name]
+		switch {
+		case node.kind == sourceNode && len(node.inputs) != 0:
+			return nil, fmt.Errorf("graph: source %s has inputs", name)
+		case node.kind == sinkNode && len(node.outputs) != 0:
+			return nil, fmt.Errorf("graph: sink %s has outputs", name)
+		case node.kind != sourceNode && len(node.inputs) == 0:
+			return nil, fmt.Errorf("graph: stage %s has no inputs", name)
+		case node.kind != sinkNode && len(node.outputs) == 0:
+			return nil, fmt.Errorf("graph: stage %s has no outputs", name)
+		}
+	}
+
+	// Kahn's algorithm, the nodes left unsorted lie on cycles
+	pending := make(map[string]int, len(g.nodes))
+	var ready []string
+	for _, name := range g.order {
+		pending[name] = len(g.nodes[name].inputs)
+		if pending[name] == 0 {
+			ready = append(ready, name)
+		}
+	}
+
+	sorted := make([]*graphNode, 0, len(g.nodes))
+	for len(ready) != 0 {
+		node := g.nodes[ready[0]]
+		ready = ready[1:]
+		sorted = append(sorted, node)
+
+		for _, next := range node.outputs {
+			pending[next]--
+			if pending[next] == 0 {
+				ready = append(ready, next)
+			}
+		}
+	}
+
+	if len(sorted) != len(g.nodes) {
+
+		// the unsorted nodes that only lead out of the cycles are dropped
+		left := make(map[string]bool)
+		for name, count := range pending {
+			if count > 0 {
+				left[name] = true
+			}
+		}
+		for dropped := true; dropped; {
+			dropped = false
+			for name := range left {
+				leads := false
+				for _, next := range g.nodes[name].outputs {
+					leads = leads || left[next]
+				}
+				if !leads {
+					delete(left, name)
+					dropped = true
+				}
+			}
+		}
+
+		var cycle []string
+		for name := range left {
+			cycle = append(cycle, name)
+		}
+		sort.Strings(cycle)
+		return nil, fmt.Errorf("graph: cycle through %s", strings.Join(cycle, ", "))
+	}
+
+	return sorted, nil
+}
+
+// Run executes the graph, it returns the first error of any stage
+func (g *Graph) Run(ctx context.Context) error {
+
+	nodes, err := g.sorted()
+	if err != nil {
+		return err
+	}
+
+	r := &run{g: newGroup(ctx), metrics: metricsFor(ctx)}
+	defer r.metrics.finish()
+
+	metrics := make(map[string]*StageMetrics, len(nodes))
+	for _, node := range nodes {
+		metrics[node.name] = r.metrics.addStage(node.name)
+		metrics[node.name].fixed = true
+	}
+
+	// every connection is a channel of its own buffered by an edge
+	edges := make(map[[2]string]chan interface{})
+	buffered := make(map[[2]string]chan interface{})
+	for _, node := range nodes {
+		for _, next := range node.outputs {
+			key := [2]string{node.name, next}
+			e := r.metrics.addEdge(metrics[node.name], metrics[next], g.edges[key])
+			edges[key] = make(chan interface{})
+			buffered[key] = connect(r.g, nil, nil, e, edges[key])
+		}
+	}
+
+	for _, node := range nodes {
+
+		var in, out chan interface{}
+
+		if node.kind != sourceNode {
+			inputs := make([]chan interface{}, len(node.inputs))
+			for i, previous := range node.inputs {
+				inputs[i] = buffered[[2]string{previous, node.name}]
+			}
+			in = merge(r.g, metrics[node.name], inputs)
+		}
+
+		if node.kind != sinkNode {
+			outputs := make([]chan interface{}, len(node.outputs))
+			for i, next := range node.outputs {
+				outputs[i] = edges[[2]string{node.name, next}]
+			}
+			out = make(chan interface{})
+			distribute(r.g, metrics[node.name], node.fanout, out, outputs)
+		}
+
+		func(node *graphNode, in, out chan interface{}) {
+			launch(r.g, metrics[node.name], in, out, func(ctx context.Context) error {
+				return node.run(ctx, in, out)
+			})
+		}(node, in, out)
+	}
+
+	return completed(ctx, r.g.Wait())
+}
+
+// distribute passes the output of a stage to the connections, they are closed along with the output
+func distribute(g *group, m *StageMetrics, fanout Fanout, out chan interface{}, outputs []chan interface{}) {
+	g.Go(func(ctx context.Context) error {
+		clock := clockFrom(ctx)
+		defer func() {
+			for _, output := range outputs {
+				close(output)
+			}
+		}()
+
+		for next := 0; ; next++ {
+			value, ok := <-out
+			if !ok {
+				return nil
+			}
+
+			received := clock.Now()
+			m.sent()
+
+			targets := outputs
+			if fanout == RoundRobin {
+				targets = outputs[next%len(outputs) : next%len(outputs)+1]
+			}
+
+			for _, target := range targets {
+				select {
+				case target <- value:
+				case <-ctx.Done():
+					drain(out)
+					return nil
+				}
+			}
+
+			m.stalled(clock.Now().Sub(received))
+		}
+	})
+}
+
+// merge joins the connections into the input of a stage, closed once all of them are
+func merge(g *group, m *StageMetrics, inputs []chan interface{}) chan interface{} {
+
+	in := make(chan interface{})
+	wg := &sync.WaitGroup{}
+	wg.Add(len(inputs))
+
+	for _, input := range inputs {
+		func(input chan interface{}) {
+			g.Go(func(ctx context.Context) error {
+				defer wg.Done()
+				clock := clockFrom(ctx)
+
+				for {
+					start := clock.Now()
+					value, ok := <-input
+					if !ok {
+						return nil
+					}
+
+					m.starved(clock.Now().Sub(start))
+
+					select {
+					case in <- value:
+					case <-ctx.Done():
+						drain(input)
+						return nil
+					}
+
+					m.received()
+				}
+			})
+		}(input)
+	}
+
+	g.Go(func(context.Context) error {
+		wg.Wait()
+		close(in)
+		return nil
+	})
+
+	return in
+}
@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// intoSlice is a graph sink appending everything it receives to result
+func intoSlice(mu *sync.Mutex, result *[]string) Sink[interface{}] {
+	return func(ctx context.Context, in chan interface{}) error {
+		for value := range in {
+			mu.Lock()
+			*result = append(*result, value.(string))
+			mu.Unlock()
+		}
+		return nil
+	}
+}
+
+// signWith is a graph stage signing every value with the signer
+func signWith(prefix string, signer *func(string) string) Stage[interface{}, interface{}] {
+	return func(ctx context.Context, in, out chan interface{}) error {
+		for value := range in {
+			if err := send[interface{}](ctx, out, prefix+(*signer)(value.(string))); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+func TestGraphBroadcast(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	mu := &sync.Mutex{}
+	var result []string
+
+	graph := NewGraph().
+		AddSource("numbers", func(ctx context.Context, out chan interface{}) error {
+			for _, value := range []string{"0", "1"} {
+				if err := send[interface{}](ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		AddStage("crc32", signWith("crc32 ", &DataSignerCrc32)).
+		AddStage("md5", signWith("md5 ", &DataSignerMd5)).
+		AddSink("join", intoSlice(mu, &result)).
+		Connect("numbers", "crc32").
+		Connect("numbers", "md5").
+		Connect("crc32", "join").
+		Connect("md5", "join")
+
+	m := &Metrics{}
+	if err := graph.Run(RecordMetrics(context.Background(), m)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Strings(result)
+	expected := "crc32 2212294583,crc32 4108050209,md5 c4ca4238a0b923820dcc509a6f75849b,md5 cfcd208495d565ef66e7dff9f98764da"
+	if got := strings.Join(result, ","); got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+
+	if join, _ := m.Snapshot().Stage("join"); join.ItemsIn != 4 {
+		t.Errorf("wrong number of joined items\nGot: %d\nExpected: %d", join.ItemsIn, 4)
+	}
+}
+
+func TestGraphRoundRobin(t *testing.T) {
+
+	mu := &sync.Mutex{}
+	var result []string
+
+	tag := func(prefix string) Stage[interface{}, interface{}] {
+		return func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				if err := send[interface{}](ctx, out, prefix+value.(string)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+
+	err := NewGraph().
+		AddSource("letters", func(ctx context.Context, out chan interface{}) error {
+			for _, value := range []string{"a", "b", "c", "d"} {
+				if err := send[interface{}](ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		}).
+		AddStage("left", tag("L")).
+		AddStage("right", tag("R")).
+		AddSink("collect", intoSlice(mu, &result)).
+		Connect("letters", "left").
+		Connect("letters", "right").
+		SetFanout("letters", RoundRobin).
+		Connect("left", "collect").
+		Connect("right", "collect").
+		Run(context.Background())
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	sort.Strings(result)
+	expected := "La,Lc,Rb,Rd"
+	if got := strings.Join(result, ","); got != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, expected)
+	}
+}
+
+func TestGraphValidate(t *testing.T) {
+
+	pass := func(ctx context.Context, in, out chan interface{}) error {
+		for value := range in {
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+	source := func(ctx context.Context, out chan interface{}) error { return nil }
+	sink := func(ctx context.Context, in chan interface{}) error { return nil }
+
+	cases := map[string]*Graph{
+		"graph: cycle through b, c": NewGraph().
+			AddSource("a", source).AddStage("b", pass).AddStage("c", pass).AddSink("d", sink).
+			Connect("a", "b").Connect("b", "c").Connect("c", "b").Connect("c", "d"),
+		"graph: stage b has no outputs": NewGraph().
+			AddSource("a", source).AddStage("b", pass).Connect("a", "b"),
+		"graph: unknown stage x": NewGraph().
+			AddSource("a", source).Connect("a", "x"),
+		"graph: duplicate stage a": NewGraph().
+			AddSource("a", source).AddSink("a", sink),
+	}
+
+	for expected, graph := range cases {
+		if err := graph.Run(context.Background()); err == nil || err.Error() != expected {
+			t.Errorf("wrong error\nGot: %v\nExpected: %v", err, expected)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// Guard restricts the use of an expensive resource: how many calls run at once
+// and how many start per second. Waiting calls are let in in the order they came.
+type Guard struct {
+	mu          sync.Mutex
+	concurrency int
+	interval    time.Duration // the minimal time between two starts
+	running     int
+	next        time.Time // when the next call may start
+	queue       []chan struct{}
+	waking      bool  // a timer wakes the queue once the rate allows the next start
+	clock       Clock // measures the rate
+}
+
+// NewGuard creates a guard letting in at most concurrency calls at once and at most rate calls per second
+// measured by the clock, zero or less removes the respective limit and a nil clock is the system one
+func NewGuard(concurrency int, rate float64, clock Clock) *Guard {
+	if clock == nil {
+		clock = SystemClock
+	}
+	g := &Guard{concurrency: concurrency, clock: clock}
+	if rate > 0 {
+		g.interval = time.Duration(float64(time.Second) / rate)
+	}
+	return g
+}
+
+// Acquire waits for the turn of the caller unless ctx is done first, a successful call must be followed by Release
+func (g *Guard) Acquire(ctx context.Context) error {
+
+	if err := ctx.Err(); err != nil {
+		return err
+	}
+
+	ready := make(chan struct{})
+
+	g.mu.Lock()
+	g.queue = append(g.queue, ready)
+	g.dispatch()
+	g.mu.Unlock()
+
+	select {
+	case <-ready:
+		return nil
+	case <-ctx.Done():
+	}
+
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	select {
+	case <-ready:
+		// the turn came along with the cancellation, it is passed on
+		g.running--
+	default:
+		for i, waiting := range g.queue {
+			if waiting == ready {
+				g.queue = append(g.queue[:i], g.queue[i+1:]...)
+				break
+			}
+		}
+	}
+	g.dispatch()
+
+	return ctx.Err()
+}
+
+// Release ends a call let in by Acquire
+func (g *Guard) Release() {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+
+	g.running--
+	g.dispatch()
+}
+
+// Wrap returns f restricted by the guard
+func (g *Guard) Wrap(f func(string) string) func(ctx context.Context, data string) (string, error) {
+	return func(ctx context.Context, data string) (string, error) {
+		if err := g.Acquire(ctx); err != nil {
+			return "", err
+		}
+		defer g.Release()
+
+		return f(data), nil
+	}
+}
+
+// dispatch lets in the waiting calls the limits allow, it is called with the mutex held
+func (g *Guard) dispatch() {
+	for len(g.queue) != 0 && (g.concurrency <= 0 || g.running < g.concurrency) {
+
+		now := g.clock.Now()
+		if g.interval > 0 {
+			if now.Before(g.next) {
+				if !g.waking {
+					g.waking = true
+					g.clock.AfterFunc(g.next.Sub(now), func() {
+						g.mu.Lock()
+						defer g.mu.Unlock()
+
+						g.waking = false
+						g.dispatch()
+					})
+				}
+				return
+			}
+			g.next = now.Add(g.interval)
+		}
+
+		ready := g.queue[0]
+		g.queue = g.queue[1:]
+		g.running++
+		close(ready)
+	}
+}
@@ -0,0 +1,115 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestGuardConcurrency(t *testing.T) {
+
+	tracker := &concurrency{}
+	slow := tracker.wrap(func(data string) string {
+		time.Sleep(10 * time.Millisecond)
+		return data
+	})
+	guarded := NewGuard(3, 0, nil).Wrap(slow)
+
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := guarded(context.Background(), "x"); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if tracker.max != 3 {
+		t.Errorf("wrong number of concurrent calls\nGot: %d\nExpected: %d", tracker.max, 3)
+	}
+}
+
+func TestGuardRate(t *testing.T) {
+
+	guarded := NewGuard(0, 100, nil).Wrap(func(data string) string { return data })
+
+	start := time.Now()
+	for i := 0; i < 6; i++ {
+		if _, err := guarded(context.Background(), "x"); err != nil {
+			t.Fatalf("unexpected error: %v", err)
+		}
+	}
+
+	// the first call starts at once, the other five wait 10ms each
+	if elapsed := time.Since(start); elapsed < 50*time.Millisecond {
+		t.Errorf("rate is not limited: 6 calls in %s", elapsed)
+	}
+}
+
+func TestGuardFairness(t *testing.T) {
+
+	g := NewGuard(1, 0, nil)
+	if err := g.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	mu := &sync.Mutex{}
+	var order []int
+	wg := &sync.WaitGroup{}
+
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if err := g.Acquire(context.Background()); err != nil {
+				t.Errorf("unexpected error: %v", err)
+				return
+			}
+			mu.Lock()
+			order = append(order, i)
+			mu.Unlock()
+			g.Release()
+		}(i)
+
+		// waiting for the call to join the queue
+		for queued := false; !queued; time.Sleep(time.Millisecond) {
+			g.mu.Lock()
+			queued = len(g.queue) == i+1
+			g.mu.Unlock()
+		}
+	}
+
+	g.Release()
+	wg.Wait()
+
+	if got := fmt.Sprint(order); got != "[0 1 2 3 4]" {
+		t.Errorf("calls are not served in order\nGot: %v\nExpected: %v", got, "[0 1 2 3 4]")
+	}
+}
+
+func TestGuardCancel(t *testing.T) {
+
+	g := NewGuard(1, 0, nil)
+	if err := g.Acquire(context.Background()); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if err := g.Acquire(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+
+	// the cancelled call must not hold a place in the queue
+	g.Release()
+	if err := g.Acquire(context.Background()); err != nil {
+		t.Errorf("unexpected error: %v", err)
+	}
+}
@@ -0,0 +1,510 @@
+package main
+
+import (
+	"context"
+	"expvar"
+	"reflect"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// keptMetrics is how many finished runs stay visible next to the running ones
+const keptMetrics = 10
+
+// Metrics is the state of a single pipeline run, it is safe for concurrent use
+type Metrics struct {
+	mu       sync.Mutex
+	id       int64
+	started  time.Time
+	finished time.Time
+	stages   []*StageMetrics
+	edges    []*EdgeMetrics
+	clock    Clock
+}
+
+// StageMetrics is the state of a single stage of a run.
+// Items and blocking are measured on the channels around the stage,
+// workers and latency are reported by stages running a worker pool.
+type StageMetrics struct {
+	itemsIn     int64
+	itemsOut    int64
+	blockedRecv int64 // nanoseconds the stage waited for input
+	blockedSend int64 // nanoseconds the stage waited for the next stage to take its output
+	workers     int64
+	busy        int64
+
+	id int // the position of the stage in the run
+
+	// the edges without a buffer have no relay measuring them, the stage measures its side itself
+	directIn, directOut bool
+	receiving, sending  waits
+
+	mu      sync.Mutex
+	name    string
+	fixed   bool      // the name was given by the user, the stage does not rename itself
+	latency histogram // the time the workers spent on an item
+}
+
+// MetricsSnapshot is a copy of the metrics of a run
+type MetricsSnapshot struct {
+	ID       int64
+	Running  bool
+	Started  time.Time
+	Finished time.Time `json:",omitempty"`
+	Stages   []StageSnapshot
+	Edges    []EdgeSnapshot
+}
+
+// StageSnapshot is a copy of the metrics of a stage
+type StageSnapshot struct {
+	ID          int // the position of the stage in the run, the names may repeat
+	Name        string
+	ItemsIn     int64
+	ItemsOut    int64
+	BlockedRecv time.Duration
+	BlockedSend time.Duration
+	Workers     int64
+	Busy        int64
+	Utilization float64 // the share of busy workers
+	Latency     HistogramSnapshot
+}
+
+// RecordMetrics makes the pipeline running with ctx record its metrics into m
+func RecordMetrics(ctx context.Context, m *Metrics) context.Context {
+	return context.WithValue(ctx, metricsKey{}, m)
+}
+
+type metricsKey struct{}
+
+type stageMetricsKey struct{}
+
+type nestedKey struct{}
+
+// stageMetricsFrom returns the metrics of the stage running with ctx or nil
+func stageMetricsFrom(ctx context.Context) *StageMetrics {
+	m, _ := ctx.Value(stageMetricsKey{}).(*StageMetrics)
+	return m
+}
+
+// nested makes the stages nested in the stage running with ctx leave measuring its edges and naming it to the stage
+func nested(ctx context.Context) context.Context {
+	return context.WithValue(ctx, nestedKey{}, true)
+}
+
+// measuring returns the metrics of the stage running with ctx unless it is nested in another one
+func measuring(ctx context.Context) *StageMetrics {
+	if ctx.Value(nestedKey{}) != nil {
+		return nil
+	}
+	return stageMetricsFrom(ctx)
+}
+
+// takeItem takes the next value of the stage running with ctx from its input unless ctx is done first.
+// Over an edge without a buffer it accounts the item and the wait for it.
+func takeItem[T any](ctx context.Context, in chan T) (value T, ok bool, err error) {
+	m := measuring(ctx)
+	if m == nil || !m.directIn {
+		return receive(ctx, in)
+	}
+
+	clock := clockFrom(ctx)
+	m.receiving.begin(clock.Now())
+	value, ok, err = receive(ctx, in)
+	m.receiving.end(clock.Now())
+	if ok {
+		m.received()
+	}
+	return value, ok, err
+}
+
+// passItem passes the value of the stage running with ctx to its output unless ctx is done first.
+// Over an edge without a buffer it accounts the item and the wait for the next stage to take it.
+func passItem[T any](ctx context.Context, out chan T, value T) error {
+	m := measuring(ctx)
+	if m == nil || !m.directOut {
+		return send(ctx, out, value)
+	}
+
+	passed := m.passing(clockFrom(ctx))
+	err := send(ctx, out, value)
+	passed(err == nil)
+	return err
+}
+
+// passing begins a send of the stage over its output edge without a buffer, the returned function ends it.
+// Nil metrics are ignored.
+func (s *StageMetrics) passing(clock Clock) (passed func(ok bool)) {
+	if s == nil {
+		return func(bool) {}
+	}
+
+	s.sending.begin(clock.Now())
+	return func(ok bool) {
+		s.sending.end(clock.Now())
+		if ok {
+			s.sent()
+		}
+	}
+}
+
+// waits measures the time a stage waits on an edge without a buffer. The pool workers may wait
+// at once, the time is counted while any of them does.
+type waits struct {
+	mu      sync.Mutex
+	waiting int
+	since   time.Time
+	total   time.Duration // the time of the finished waits
+}
+
+func (w *waits) begin(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.waiting == 0 {
+		w.since = now
+	}
+	w.waiting++
+}
+
+func (w *waits) end(now time.Time) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	w.waiting--
+	if w.waiting == 0 {
+		w.total += now.Sub(w.since)
+	}
+}
+
+// waited returns the time waited including the current wait and how long the current one lasts
+func (w *waits) waited(now time.Time) (total, current time.Duration) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	if w.waiting > 0 {
+		current = now.Sub(w.since)
+	}
+	return w.total + current, current
+}
+
+// nameStage gives the stage running with ctx the name of what it runs, such as the name of an Untyped stage,
+// which its pipeline can't tell from the function. A nested stage or one named by the user keeps its name.
+func nameStage(ctx context.Context, name string) {
+	if m := measuring(ctx); m != nil {
+		m.mu.Lock()
+		if !m.fixed {
+			m.name = name
+		}
+		m.mu.Unlock()
+	}
+}
+
+// registry keeps the runs shown by expvar
+var registry = struct {
+	mu       sync.Mutex
+	lastID   int64
+	running  map[int64]*Metrics
+	finished []*Metrics
+}{running: make(map[int64]*Metrics)}
+
+func init() {
+	expvar.Publish("pipelines", expvar.Func(func() interface{} {
+		return Pipelines()
+	}))
+}
+
+// Pipelines returns the metrics of the running pipelines and of the last finished ones
+func Pipelines() []MetricsSnapshot {
+
+	registry.mu.Lock()
+	runs := make([]*Metrics, 0, len(registry.running)+len(registry.finished))
+	runs = append(runs, registry.finished...)
+	for _, m := range registry.running {
+		runs = append(runs, m)
+	}
+	registry.mu.Unlock()
+
+	snapshots := make([]MetricsSnapshot, 0, len(runs))
+	for _, m := range runs {
+		snapshots = append(snapshots, m.Snapshot())
+	}
+
+	// ordering by the start of the run
+	for i := 1; i < len(snapshots); i++ {
+		for j := i; j > 0 && snapshots[j].ID < snapshots[j-1].ID; j-- {
+			snapshots[j], snapshots[j-1] = snapshots[j-1], snapshots[j]
+		}
+	}
+
+	return snapshots
+}
+
+// metricsFor returns the metrics the run with ctx should record into and registers the run
+func metricsFor(ctx context.Context) *Metrics {
+
+	m, _ := ctx.Value(metricsKey{}).(*Metrics)
+	if m == nil {
+		m = &Metrics{}
+	}
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	registry.lastID++
+
+	m.mu.Lock()
+	m.id = registry.lastID
+	m.clock = clockFrom(ctx)
+	m.started = m.clock.Now()
+	m.finished = time.Time{}
+	m.stages = nil
+	m.edges = nil
+	m.mu.Unlock()
+
+	registry.running[m.id] = m
+	return m
+}
+
+// finish marks the run as finished and keeps it among the last ones
+func (m *Metrics) finish() {
+
+	m.mu.Lock()
+	m.finished = m.clock.Now()
+	id := m.id
+	m.mu.Unlock()
+
+	registry.mu.Lock()
+	defer registry.mu.Unlock()
+
+	delete(registry.running, id)
+	registry.finished = append(registry.finished, m)
+	if len(registry.finished) > keptMetrics {
+		registry.finished = registry.finished[len(registry.finished)-keptMetrics:]
+	}
+}
+
+// addStage registers the next stage of the run
+func (m *Metrics) addStage(name string) *StageMetrics {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	s := &StageMetrics{id: len(m.stages), name: name}
+	m.stages = append(m.stages, s)
+	return s
+}
+
+// Snapshot copies the current metrics
+func (m *Metrics) Snapshot() MetricsSnapshot {
+	m.mu.Lock()
+	snapshot := MetricsSnapshot{
+		ID:       m.id,
+		Running:  m.finished.IsZero(),
+		Started:  m.started,
+		Finished: m.finished,
+	}
+	stages := append([]*StageMetrics(nil), m.stages...)
+	edges := append([]*EdgeMetrics(nil), m.edges...)
+	clock := m.clock
+	m.mu.Unlock()
+
+	if clock == nil {
+		clock = SystemClock
+	}
+	now := clock.Now()
+
+	for _, s := range stages {
+		snapshot.Stages = append(snapshot.Stages, s.snapshot(now))
+	}
+	for _, e := range edges {
+		snapshot.Edges = append(snapshot.Edges, e.snapshot(now))
+	}
+	return snapshot
+}
+
+// Stage returns the snapshot of the first stage with the name
+func (s MetricsSnapshot) Stage(name string) (StageSnapshot, bool) {
+	for _, stage := range s.Stages {
+		if stage.Name == name {
+			return stage, true
+		}
+	}
+	return StageSnapshot{}, false
+}
+
+// snapshot copies the metrics of the stage, the waits still going on at now are counted so far
+func (s *StageMetrics) snapshot(now time.Time) StageSnapshot {
+	receiving, _ := s.receiving.waited(now)
+	sending, _ := s.sending.waited(now)
+
+	snapshot := StageSnapshot{
+		ID:          s.id,
+		ItemsIn:     atomic.LoadInt64(&s.itemsIn),
+		ItemsOut:    atomic.LoadInt64(&s.itemsOut),
+		BlockedRecv: time.Duration(atomic.LoadInt64(&s.blockedRecv)) + receiving,
+		BlockedSend: time.Duration(atomic.LoadInt64(&s.blockedSend)) + sending,
+		Workers:     atomic.LoadInt64(&s.workers),
+		Busy:        atomic.LoadInt64(&s.busy),
+	}
+	if snapshot.Workers > 0 {
+		snapshot.Utilization = float64(snapshot.Busy) / float64(snapshot.Workers)
+	}
+
+	s.mu.Lock()
+	snapshot.Name = s.name
+	snapshot.Latency = s.latency.snapshot()
+	s.mu.Unlock()
+
+	return snapshot
+}
+
+func (s *StageMetrics) stageName() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return s.name
+}
+
+// received accounts an item taken by the stage, nil metrics are ignored
+func (s *StageMetrics) received() {
+	if s != nil {
+		atomic.AddInt64(&s.itemsIn, 1)
+	}
+}
+
+// starved accounts the time the stage had no input to take, nil metrics are ignored
+func (s *StageMetrics) starved(waited time.Duration) {
+	if s != nil {
+		atomic.AddInt64(&s.blockedRecv, int64(waited))
+	}
+}
+
+// sent accounts an item emitted by the stage, nil metrics are ignored
+func (s *StageMetrics) sent() {
+	if s != nil {
+		atomic.AddInt64(&s.itemsOut, 1)
+	}
+}
+
+// processed accounts the time a worker spent on an item, nil metrics are ignored
+func (s *StageMetrics) processed(d time.Duration) {
+	if s == nil {
+		return
+	}
+
+	s.mu.Lock()
+	s.latency.observe(d)
+	s.mu.Unlock()
+}
+
+// stalled accounts the time the stage could not pass its output on, nil metrics are ignored
+func (s *StageMetrics) stalled(waited time.Duration) {
+	if s != nil {
+		atomic.AddInt64(&s.blockedSend, int64(waited))
+	}
+}
+
+// addWorkers changes the size of the worker pool of the stage, nil metrics are ignored
+func (s *StageMetrics) addWorkers(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.workers, int64(n))
+	}
+}
+
+// working changes the number of busy workers of the stage, nil metrics are ignored
+func (s *StageMetrics) working(n int) {
+	if s != nil {
+		atomic.AddInt64(&s.busy, int64(n))
+	}
+}
+
+// histogram counts latencies in exponential buckets
+type histogram struct {
+	counts [len(latencyBounds) + 1]int64
+	count  int64
+	sum    time.Duration
+	max    time.Duration
+}
+
+// latencyBounds are the upper bounds of the histogram buckets, the last bucket has no bound
+var latencyBounds = [...]time.Duration{
+	time.Millisecond,
+	5 * time.Millisecond,
+	10 * time.Millisecond,
+	50 * time.Millisecond,
+	100 * time.Millisecond,
+	500 * time.Millisecond,
+	time.Second,
+	5 * time.Second,
+	10 * time.Second,
+}
+
+// HistogramSnapshot is a copy of a latency histogram
+type HistogramSnapshot struct {
+	Count   int64
+	Sum     time.Duration
+	Max     time.Duration
+	Buckets []Bucket
+}
+
+// Bucket counts the latencies up to the bound, a zero bound stands for the rest
+type Bucket struct {
+	UpTo  time.Duration `json:",omitempty"`
+	Count int64
+}
+
+func (h *histogram) observe(d time.Duration) {
+	i := 0
+	for i < len(latencyBounds) && d > latencyBounds[i] {
+		i++
+	}
+	h.counts[i]++
+	h.count++
+	h.sum += d
+	if d > h.max {
+		h.max = d
+	}
+}
+
+func (h *histogram) snapshot() HistogramSnapshot {
+	snapshot := HistogramSnapshot{Count: h.count, Sum: h.sum, Max: h.max}
+	for i, count := range h.counts {
+		bucket := Bucket{Count: count}
+		if i < len(latencyBounds) {
+			bucket.UpTo = latencyBounds[i]
+		}
+		snapshot.Buckets = append(snapshot.Buckets, bucket)
+	}
+	return snapshot
+}
+
+// Mean returns the average latency
+func (h HistogramSnapshot) Mean() time.Duration {
+	if h.Count == 0 {
+		return 0
+	}
+	return h.Sum / time.Duration(h.Count)
+}
+
+// funcPointer identifies a function, it tells apart the top-level ones only
+func funcPointer(f interface{}) uintptr {
+	return reflect.ValueOf(f).Pointer()
+}
+
+// funcName returns a readable name of a function, such as SingleHash or TestChain.func1
+func funcName(f interface{}) string {
+	fn := runtime.FuncForPC(funcPointer(f))
+	if fn == nil {
+		return "stage"
+	}
+	name := fn.Name()
+	if i := strings.LastIndex(name, "/"); i >= 0 {
+		name = name[i+1:]
+	}
+	if i := strings.Index(name, "."); i >= 0 {
+		name = name[i+1:]
+	}
+	return name
+}
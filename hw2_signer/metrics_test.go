@@ -0,0 +1,152 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"expvar"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestMetrics(t *testing.T) {
+
+	slow := func(ctx context.Context, value int) (int, error) {
+		time.Sleep(20 * time.Millisecond)
+		return value, nil
+	}
+
+	// the edges with a buffer are measured by their relays, the direct ones by the stages on their ends
+	for _, edge := range []struct {
+		name string
+		opts []EdgeOption
+	}{
+		{"direct", nil},
+		{"buffered", []EdgeOption{EdgeBuffer(1)}},
+	} {
+		t.Run(edge.name, func(t *testing.T) {
+
+			m := &Metrics{}
+			ctx := RecordMetrics(context.Background(), m)
+
+			var result []int
+			chain := Then(From(numbers(10)), Map(slow, WithWorkers(2)), edge.opts...)
+			if err := chain.Run(ctx, collect(&result), edge.opts...); err != nil {
+				t.Fatalf("unexpected error: %v", err)
+			}
+
+			snapshot := m.Snapshot()
+			if snapshot.Running || len(snapshot.Stages) != 3 {
+				t.Fatalf("wrong snapshot: %+v", snapshot)
+			}
+
+			source, worker, sink := snapshot.Stages[0], snapshot.Stages[1], snapshot.Stages[2]
+
+			if source.ItemsOut != 10 || worker.ItemsIn != 10 || worker.ItemsOut != 10 || sink.ItemsIn != 10 {
+				t.Errorf("wrong item counts: %d -> %d/%d -> %d", source.ItemsOut, worker.ItemsIn, worker.ItemsOut, sink.ItemsIn)
+			}
+			for _, e := range snapshot.Edges {
+				if e.Items != 10 {
+					t.Errorf("wrong item count of the edge %s -> %s: %d", e.From, e.To, e.Items)
+				}
+			}
+
+			// two workers can't keep up with the source, so it is the one waiting
+			if source.BlockedSend < 50*time.Millisecond {
+				t.Errorf("source should be blocked by the slow stage, blocked for %s", source.BlockedSend)
+			}
+
+			if worker.Latency.Count != 10 || worker.Latency.Mean() < 20*time.Millisecond {
+				t.Errorf("wrong latency: %d items, mean %s", worker.Latency.Count, worker.Latency.Mean())
+			}
+
+			// the pool is gone after the run
+			if worker.Workers != 0 || worker.Busy != 0 {
+				t.Errorf("pool is not released: %d workers, %d busy", worker.Workers, worker.Busy)
+			}
+		})
+	}
+}
+
+func TestMetricsExpvar(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 1
+			out <- 2
+		}),
+		job(SingleHash),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			<-in
+		}),
+	)
+
+	var runs []MetricsSnapshot
+	if err := json.Unmarshal([]byte(expvar.Get("pipelines").String()), &runs); err != nil {
+		t.Fatalf("can't decode expvar: %v", err)
+	}
+	if len(runs) == 0 {
+		t.Fatalf("no runs published")
+	}
+
+	last := runs[len(runs)-1]
+	if len(last.Stages) != 5 || last.Stages[0].ItemsOut != 2 || last.Stages[4].ItemsIn != 1 {
+		t.Errorf("the source and the sink are not measured: %+v", last.Stages)
+	}
+	for _, name := range []string{"SingleHash", "MultiHash", "CombineResults"} {
+		stage, ok := last.Stage(name)
+		if !ok {
+			t.Errorf("no %s in the last run: %+v", name, last)
+			continue
+		}
+		if stage.ItemsIn != 2 || stage.ItemsOut == 0 {
+			t.Errorf("wrong counts of %s: %d in, %d out", name, stage.ItemsIn, stage.ItemsOut)
+		}
+	}
+}
+
+func TestMetricsStageNames(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, MultiHashContext)
+
+	m := &Metrics{}
+	ctx := RecordMetrics(context.Background(), m)
+
+	// the Untyped stages share their function, they are named by what they run
+	err = ExecutePipelineContext(ctx,
+		func(ctx context.Context, in, out chan interface{}) error {
+			return send[interface{}](ctx, out, 1)
+		},
+		Untyped("SingleHash", NewSingleHash()),
+		RemoteStage("tcp", l.Addr().String(), WithName("MultiHash")),
+		Untyped("CombineResults", NewCombineResults()),
+		func(ctx context.Context, in, out chan interface{}) error {
+			drain(in)
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	snapshot := m.Snapshot()
+	for i, name := range []string{"SingleHash", "MultiHash", "CombineResults"} {
+		stage, ok := snapshot.Stage(name)
+		if !ok || stage.ID != i+1 {
+			t.Errorf("no stage %s at %d in %+v", name, i+1, snapshot.Stages)
+		}
+	}
+	if edge := snapshot.Edges[1]; edge.From != "SingleHash" || edge.To != "MultiHash" || edge.FromID != 1 || edge.ToID != 2 {
+		t.Errorf("wrong edge: %+v", edge)
+	}
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"sync"
+)
+
+// stage is a job that can be cancelled through ctx and can fail
+type stage = Stage[interface{}, interface{}]
+
+// ExecutePipelineContext runs stages as a pipeline. The first stage that fails
+// cancels the others, the channels are drained, and its error is returned
+// once every stage has stopped.
+func ExecutePipelineContext(ctx context.Context, stages ...stage) error {
+
+	names := make([]string, len(stages))
+	for i, s := range stages {
+		names[i] = funcName(s)
+	}
+
+	return executePipeline(ctx, names, stages)
+}
+
+// executePipeline runs the stages as a chain, the names are used in the metrics
+func executePipeline(ctx context.Context, names []string, stages []stage) error {
+
+	if len(stages) < 2 {
+		return nil
+	}
+
+	first, last := stages[0], stages[len(stages)-1]
+
+	chain := from(names[0], func(ctx context.Context, out chan interface{}) error {
+		return first(ctx, nil, out)
+	})
+
+	for i, s := range stages[1 : len(stages)-1] {
+		chain = then(chain, names[i+1], s)
+	}
+
+	return chain.run(ctx, names[len(names)-1], func(ctx context.Context, in chan interface{}) error {
+		return last(ctx, in, nil)
+	})
+}
+
+// group runs goroutines sharing a context, the first error cancels the context
+type group struct {
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+	once   sync.Once
+	err    error
+}
+
+func newGroup(ctx context.Context) *group {
+	ctx, cancel := context.WithCancel(ctx)
+	return &group{ctx: ctx, cancel: cancel}
+}
+
+// Go starts f in a new goroutine, a panic in f is turned into an error
+func (g *group) Go(f func(ctx context.Context) error) {
+	g.wg.Add(1)
+	go func() {
+		defer g.wg.Done()
+		g.fail(safely(func() error {
+			return f(g.ctx)
+		}))
+	}()
+}
+
+// fail records the first error and cancels the group, nil errors are ignored
+func (g *group) fail(err error) {
+	if err == nil {
+		return
+	}
+	g.once.Do(func() {
+		g.err = err
+		g.cancel()
+	})
+}
+
+// Wait blocks until all goroutines return and reports the first error
+func (g *group) Wait() error {
+	g.wg.Wait()
+	g.cancel()
+	return g.err
+}
+
+// safely calls f and turns its panic into an error
+func safely(f func() error) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("panic: %v", r)
+		}
+	}()
+	return f()
+}
+
+// send passes value to out unless ctx is done first
+func send[T any](ctx context.Context, out chan T, value T) error {
+	select {
+	case out <- value:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// receive takes the next value from in unless ctx is done first, ok is false once in is closed
+func receive[T any](ctx context.Context, in chan T) (value T, ok bool, err error) {
+	select {
+	case value, ok = <-in:
+		return value, ok, nil
+	case <-ctx.Done():
+		return value, false, ctx.Err()
+	}
+}
+
+// drain discards everything left in the channel until it is closed
+func drain[T any](in chan T) {
+	if in == nil {
+		return
+	}
+	for range in {
+	}
+}
@@ -0,0 +1,128 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPipelineContextError(t *testing.T) {
+
+	failure := errors.New("stage failed")
+	stopped := make(chan struct{})
+
+	stages := []stage{
+		// an endless source that only stops on cancellation
+		func(ctx context.Context, in, out chan interface{}) error {
+			defer close(stopped)
+			for i := 0; ; i++ {
+				if err := send[interface{}](ctx, out, i); err != nil {
+					return err
+				}
+			}
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				if value.(int) == 10 {
+					return failure
+				}
+				if err := send(ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for range in {
+			}
+			return nil
+		},
+	}
+
+	err := ExecutePipelineContext(context.Background(), stages...)
+	if err != failure {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, failure)
+	}
+
+	select {
+	case <-stopped:
+	default:
+		t.Errorf("source was not stopped")
+	}
+}
+
+func TestPipelineContextBadInput(t *testing.T) {
+
+	start := time.Now()
+
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return send(ctx, out, "not a number")
+		},
+		SingleHashContext,
+		MultiHashContext,
+		CombineResultsContext,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for range in {
+			}
+			return nil
+		},
+	)
+
+	if err == nil || !strings.Contains(err.Error(), "can`t convert source") {
+		t.Errorf("expected a conversion error, got %v", err)
+	}
+
+	if end := time.Since(start); end > 500*time.Millisecond {
+		t.Errorf("execution too long\nGot: %s\nExpected: <%s", end, 500*time.Millisecond)
+	}
+}
+
+func TestPipelineContextPanic(t *testing.T) {
+
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return send(ctx, out, 1)
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				_ = value.(string)
+			}
+			return nil
+		},
+	)
+
+	if err == nil || !strings.HasPrefix(err.Error(), "panic:") {
+		t.Errorf("expected a recovered panic, got %v", err)
+	}
+}
+
+func TestPipelineContextCancel(t *testing.T) {
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	err := ExecutePipelineContext(ctx,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for {
+				if err := send(ctx, out, 1); err != nil {
+					return err
+				}
+			}
+		},
+		func(ctx context.Context, in, out chan interface{}) error {
+			for {
+				_, ok, err := receive(ctx, in)
+				if err != nil || !ok {
+					return err
+				}
+			}
+		},
+	)
+
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, context.DeadlineExceeded)
+	}
+}
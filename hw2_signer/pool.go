@@ -0,0 +1,421 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWorkers     = 7
+	defaultMultiHashTh = 6
+)
+
+// Option configures a stage built by NewSingleHash, NewMultiHash or Map
+type Option func(*config)
+
+type config struct {
+	name       string        // the stage name used for dead letters and by remote stages in the metrics
+	workers    int           // the number of values processed at once, the minimum of an autoscaling pool
+	maxWorkers int           // the size an autoscaling pool may grow to
+	latency    time.Duration // an autoscaling pool does not grow while items take longer, 0 means no limit
+	buffer     int           // how many values may wait for a free worker
+	limit      int           // how many restricted signer calls may run at once, 0 means no limit
+	rate       float64       // how many restricted signer calls may start per second, 0 means no limit
+	clock      Clock         // measures the rate, the system clock by default
+	th         int           // the number of hashes MultiHash concatenates
+	ordered    bool
+	cache      *SignerCache
+	budget     int    // how many bytes CombineResults keeps in memory
+	tempDir    string // where CombineResults spills the hashes beyond the budget
+	trace      io.Writer
+	retry      *RetryPolicy
+	timeout    time.Duration // how long an item may take including its retries, 0 means no limit
+	codec      string        // how a remote stage encodes the items
+	checkpoint *Checkpoint
+	params     string // the settings the results of the stage depend on besides the salt
+}
+
+// WithName sets the stage name used for dead letters, a remote stage shows it in the metrics
+func WithName(name string) Option {
+	return func(c *config) {
+		c.name = name
+	}
+}
+
+// WithWorkers sets the size of the worker pool
+func WithWorkers(n int) Option {
+	return func(c *config) {
+		c.workers = n
+	}
+}
+
+// WithAutoscale makes the worker pool grow from min up to max workers while its input backs up
+// and shrink back when the workers are idle
+func WithAutoscale(min, max int) Option {
+	return func(c *config) {
+		c.workers = min
+		c.maxWorkers = max
+	}
+}
+
+// WithTargetLatency stops an autoscaling pool from growing while its items take longer than d on average,
+// more workers would only crowd a saturated resource then
+func WithTargetLatency(d time.Duration) Option {
+	return func(c *config) {
+		c.latency = d
+	}
+}
+
+// WithBuffer sets how many incoming values may wait for a free worker
+func WithBuffer(n int) Option {
+	return func(c *config) {
+		c.buffer = n
+	}
+}
+
+// WithLimit sets how many calls of the restricted signer may run at once:
+// DataSignerMd5 in SingleHash and DataSignerCrc32 in MultiHash, 0 removes the limit.
+// DataSignerMd5 runs one call at a time in the whole process anyway, the limit can only tighten that.
+func WithLimit(n int) Option {
+	return func(c *config) {
+		c.limit = n
+	}
+}
+
+// WithRate sets how many calls of the restricted signer may start per second, 0 removes the limit
+func WithRate(rate float64) Option {
+	return func(c *config) {
+		c.rate = rate
+	}
+}
+
+// WithClock sets the clock measuring the rate of the restricted signer calls,
+// the clock of the pipeline does not reach the guards built along with the stage
+func WithClock(clock Clock) Option {
+	return func(c *config) {
+		c.clock = clock
+	}
+}
+
+// WithCache makes SingleHash and MultiHash memoize the signers in the cache
+func WithCache(cache *SignerCache) Option {
+	return func(c *config) {
+		c.cache = cache
+	}
+}
+
+// WithMemoryBudget sets how many bytes of hashes CombineResults keeps in memory, at least a kilobyte,
+// the rest is sorted in runs spilled to temporary files
+func WithMemoryBudget(bytes int) Option {
+	return func(c *config) {
+		c.budget = bytes
+	}
+}
+
+// WithTempDir sets the directory for the runs CombineResults spills, the default temporary directory is used otherwise
+func WithTempDir(dir string) Option {
+	return func(c *config) {
+		c.tempDir = dir
+	}
+}
+
+// WithTrace makes SingleHash, MultiHash and CombineResults write their steps to w
+// in the format of hw2.md, the steps of an item are written together
+func WithTrace(w io.Writer) Option {
+	return func(c *config) {
+		c.trace = w
+	}
+}
+
+// WithRetry makes the stage process a failed item again according to the policy,
+// an item exhausting its attempts fails the pipeline with an ItemError
+func WithRetry(policy RetryPolicy) Option {
+	return func(c *config) {
+		c.retry = &policy
+	}
+}
+
+// WithItemTimeout limits how long the stage may process an item including its retries,
+// an item running out of time fails the pipeline with an ItemError
+func WithItemTimeout(timeout time.Duration) Option {
+	return func(c *config) {
+		c.timeout = timeout
+	}
+}
+
+// WithCheckpoint makes the stage skip the items completed in the checkpoint and record the ones it completes,
+// the items are keyed by the stage name, the salt and settings the results depend on and their input
+func WithCheckpoint(checkpoint *Checkpoint) Option {
+	return func(c *config) {
+		c.checkpoint = checkpoint
+	}
+}
+
+// WithMultiHashTh sets the number of hashes MultiHash concatenates
+func WithMultiHashTh(n int) Option {
+	return func(c *config) {
+		c.th = n
+	}
+}
+
+// WithOrder makes the stage emit its results in the order of their input values,
+// results finished ahead of their turn wait in a reorder buffer
+func WithOrder() Option {
+	return func(c *config) {
+		c.ordered = true
+	}
+}
+
+func newConfig(defaults config, opts []Option) config {
+	c := defaults
+	for _, opt := range opts {
+		opt(&c)
+	}
+	if c.workers < 1 {
+		c.workers = 1
+	}
+	if c.maxWorkers < c.workers {
+		c.maxWorkers = c.workers
+	}
+	if c.buffer < 0 {
+		c.buffer = 0
+	}
+	return c
+}
+
+// semaphore limits concurrent access to a resource, a nil semaphore does not limit anything
+type semaphore chan struct{}
+
+func newSemaphore(limit int) semaphore {
+	if limit <= 0 {
+		return nil
+	}
+	return make(semaphore, limit)
+}
+
+// acquire waits for a free slot unless ctx is done first
+func (s semaphore) acquire(ctx context.Context) error {
+	if s == nil {
+		return ctx.Err()
+	}
+	select {
+	case s <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (s semaphore) release() {
+	if s != nil {
+		<-s
+	}
+}
+
+// traceMu keeps the steps of different items from interleaving in a shared trace
+var traceMu sync.Mutex
+
+// trace writes the lines at once unless w is nil
+func trace(w io.Writer, lines ...string) {
+	if w == nil {
+		return
+	}
+
+	traceMu.Lock()
+	defer traceMu.Unlock()
+
+	for _, line := range lines {
+		fmt.Fprintln(w, line)
+	}
+}
+
+// Map builds a stage applying process to the values by a pool of workers,
+// it accepts WithName, WithWorkers, WithAutoscale, WithTargetLatency, WithBuffer, WithOrder, WithRetry, WithItemTimeout and WithCheckpoint
+func Map[In, Out any](process func(ctx context.Context, value In) (Out, error), opts ...Option) Stage[In, Out] {
+	c := newConfig(config{name: "Map", workers: defaultWorkers}, opts)
+	return func(ctx context.Context, in chan In, out chan Out) error {
+		return runPool(ctx, c, in, out, process)
+	}
+}
+
+// sequenced is a value tagged with its position in the input
+type sequenced[T any] struct {
+	seq   int
+	value T
+	skip  bool // the value was rejected and only its position is left
+}
+
+// runPool processes the values from in by a pool of workers and sends the results to out.
+// A value that fails or panics goes to the dead letters of ctx if there are any,
+// unless it exhausted the retries or the time of the stage.
+func runPool[In, Out any](ctx context.Context, c config, in chan In, out chan Out, process func(ctx context.Context, value In) (Out, error)) error {
+
+	g := newGroup(ctx)
+	process = withCheckpoint(c, withRetries(c, process))
+	clock := clockFrom(ctx)
+	scale := newScaler(c)
+
+	// in the ordered mode a value is let in only when there is room for its result in the reorder buffer
+	var window semaphore
+	if c.ordered {
+		window = newSemaphore(c.maxWorkers + c.buffer)
+	}
+
+	// the queue lets the previous stage run ahead of busy workers
+	queue := make(chan sequenced[In], c.buffer)
+	launch[In](g, nil, nil, queue, func(ctx context.Context) error {
+		defer scale.done()
+
+		for seq := 0; ; seq++ {
+			value, ok, err := takeItem(ctx, in)
+			if err != nil || !ok {
+				return err
+			}
+
+			if err := window.acquire(ctx); err != nil {
+				return err
+			}
+			if err := feed(ctx, scale, queue, sequenced[In]{seq: seq, value: value}); err != nil {
+				return err
+			}
+		}
+	})
+
+	emit := func(ctx context.Context, result sequenced[Out]) error {
+		if result.skip {
+			return nil
+		}
+		return passItem(ctx, out, result.value)
+	}
+
+	// the scaler counts as a worker, so the pool does not end while it may still grow
+	workers := &sync.WaitGroup{}
+	workers.Add(c.workers)
+	if scale != nil {
+		workers.Add(1)
+	}
+	abandonOnExpiry(ctx, g, workers)
+
+	// during a shutdown an item is left behind once the drain timeout passes
+	abandonable := shutdownFrom(ctx) != nil
+
+	metrics := stageMetricsFrom(ctx)
+
+	if c.ordered {
+		results := make(chan sequenced[Out])
+		emit = func(ctx context.Context, result sequenced[Out]) error {
+			return send(ctx, results, result)
+		}
+
+		g.Go(func(context.Context) error {
+			workers.Wait()
+			close(results)
+			return nil
+		})
+
+		// the reorder buffer holds the results until every earlier one is sent
+		g.Go(func(ctx context.Context) error {
+			pending := make(map[int]sequenced[Out])
+			next := 0
+
+			for {
+				result, ok, err := receive(ctx, results)
+				if err != nil || !ok {
+					return err
+				}
+				pending[result.seq] = result
+
+				for result, ok := pending[next]; ok; result, ok = pending[next] {
+					if !result.skip {
+						if err := passItem(ctx, out, result.value); err != nil {
+							return err
+						}
+					}
+					delete(pending, next)
+					window.release()
+					next++
+				}
+			}
+		})
+	}
+
+	worker := func(ctx context.Context) error {
+		defer workers.Done()
+		defer metrics.addWorkers(-1)
+		defer scale.joined(-1)
+
+		for {
+			var item sequenced[In]
+			select {
+			case next, ok := <-queue:
+				if !ok {
+					return nil
+				}
+				item = next
+			case <-scale.retired():
+				return nil
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+
+			began := clock.Now()
+			metrics.working(1)
+			scale.working(1)
+			result, err := attemptOnce(ctx, abandonable, item.value, process)
+			metrics.working(-1)
+			scale.working(-1)
+			took := clock.Now().Sub(began)
+			metrics.processed(took)
+			scale.processed(took)
+
+			skip := false
+			if err != nil {
+				if ctx.Err() != nil {
+					return ctx.Err()
+				}
+				var itemErr *ItemError
+				if errors.As(err, &itemErr) {
+					return err
+				}
+				if err := reject(ctx, c.name, item.value, err); err != nil {
+					return err
+				}
+				skip = true
+			}
+
+			if err := emit(ctx, sequenced[Out]{seq: item.seq, value: result, skip: skip}); err != nil {
+				return err
+			}
+		}
+	}
+
+	// starting a worker pool
+	start := func() {
+		metrics.addWorkers(1)
+		scale.joined(1)
+		g.Go(worker)
+	}
+	for i := 0; i < c.workers; i++ {
+		start()
+	}
+
+	if scale != nil {
+		g.Go(func(ctx context.Context) error {
+			defer workers.Done()
+			return scale.run(ctx, func() {
+				workers.Add(1)
+				start()
+			})
+		})
+	}
+
+	// the pool abandoned by a shutdown ends as if its input was over
+	if err := g.Wait(); !errors.Is(err, errDrainExpired) {
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,182 @@
+package main
+
+import (
+	"context"
+	"crypto/md5"
+	"fmt"
+	"hash/crc32"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// useFastSigners replaces the signers with ones that take delay and restores them after the test,
+// they salt the data like the ones of common.go
+func useFastSigners(t *testing.T, delay time.Duration) {
+	crc32Signer, md5Signer := DataSignerCrc32, DataSignerMd5
+	t.Cleanup(func() {
+		DataSignerCrc32, DataSignerMd5 = crc32Signer, md5Signer
+	})
+
+	DataSignerCrc32 = func(data string) string {
+		time.Sleep(delay)
+		return strconv.FormatUint(uint64(crc32.ChecksumIEEE([]byte(data+DataSignerSalt))), 10)
+	}
+	DataSignerMd5 = func(data string) string {
+		time.Sleep(delay)
+		return fmt.Sprintf("%x", md5.Sum([]byte(data+DataSignerSalt)))
+	}
+}
+
+// concurrency tracks the maximum number of simultaneous calls
+type concurrency struct {
+	mu      sync.Mutex
+	current int
+	max     int
+}
+
+func (c *concurrency) wrap(f func(string) string) func(string) string {
+	return func(data string) string {
+		c.mu.Lock()
+		c.current++
+		if c.current > c.max {
+			c.max = c.current
+		}
+		c.mu.Unlock()
+
+		defer func() {
+			c.mu.Lock()
+			c.current--
+			c.mu.Unlock()
+		}()
+
+		return f(data)
+	}
+}
+
+// numbers is a source of the integers from 0 to n-1
+func numbers(n int) Source[int] {
+	return func(ctx context.Context, out chan int) error {
+		for i := 0; i < n; i++ {
+			if err := send(ctx, out, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// collect is a sink appending everything it receives to result
+func collect[T any](result *[]T) Sink[T] {
+	return func(ctx context.Context, in chan T) error {
+		for value := range in {
+			*result = append(*result, value)
+		}
+		return nil
+	}
+}
+
+func TestStageOptions(t *testing.T) {
+
+	useFastSigners(t, 5*time.Millisecond)
+
+	md5Calls := &concurrency{}
+	DataSignerMd5 = md5Calls.wrap(DataSignerMd5)
+
+	var result []string
+	chain := Then(From(numbers(20)), NewSingleHash(WithWorkers(8), WithBuffer(4), WithLimit(3)))
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 20 {
+		t.Errorf("wrong number of results\nGot: %d\nExpected: %d", len(result), 20)
+	}
+	// the md5 signer overheats on a second call, a higher limit does not loosen its guard
+	if md5Calls.max != 1 {
+		t.Errorf("md5 limit is not respected\nGot: %d\nExpected: 1", md5Calls.max)
+	}
+
+	var hashes []string
+	chain = Then(From(func(ctx context.Context, out chan string) error {
+		return send(ctx, out, "4108050209~502633748")
+	}), NewMultiHash(WithMultiHashTh(2), WithWorkers(1)))
+	if err := chain.Run(context.Background(), collect(&hashes)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "2956866606803518384"
+	if len(hashes) != 1 || hashes[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", hashes, expected)
+	}
+}
+
+func TestIndependentPipelines(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	var crc32Calls uint32
+	crc32Signer := DataSignerCrc32
+	DataSignerCrc32 = func(data string) string {
+		atomic.AddUint32(&crc32Calls, 1)
+		return crc32Signer(data)
+	}
+
+	md5Calls := &concurrency{}
+	DataSignerMd5 = md5Calls.wrap(DataSignerMd5)
+
+	wg := &sync.WaitGroup{}
+	results := make([][]string, 2)
+	stages := []Stage[int, string]{
+		NewSingleHash(WithWorkers(1), WithLimit(1)),
+		NewSingleHash(WithWorkers(4), WithLimit(2)),
+	}
+
+	for i := range stages {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			chain := Then(From(numbers(10)), stages[i])
+			if err := chain.Run(context.Background(), collect(&results[i])); err != nil {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	calls := atomic.LoadUint32(&crc32Calls)
+	if len(results[0]) != 10 || len(results[1]) != 10 || calls != 40 {
+		t.Errorf("pipelines interfered: %d and %d results, %d crc32 calls", len(results[0]), len(results[1]), calls)
+	}
+
+	// the pipelines share the md5 signer, so they share its guard as well
+	if md5Calls.max != 1 {
+		t.Errorf("concurrent md5 calls across the pipelines\nGot: %d\nExpected: 1", md5Calls.max)
+	}
+}
+
+func TestOrderedMap(t *testing.T) {
+
+	// later values finish first, so only the reorder buffer can restore the order
+	slow := func(ctx context.Context, value int) (int, error) {
+		time.Sleep(time.Duration(20-value) * time.Millisecond)
+		return value * 10, nil
+	}
+
+	var result []int
+	chain := Then(From(numbers(20)), Map(slow, WithWorkers(5), WithBuffer(2), WithOrder()))
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 20 {
+		t.Fatalf("wrong number of results\nGot: %d\nExpected: %d", len(result), 20)
+	}
+	for i, value := range result {
+		if value != i*10 {
+			t.Fatalf("results out of order\nGot: %v", result)
+		}
+	}
+}
@@ -0,0 +1,444 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/binary"
+	"encoding/gob"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+)
+
+// A remote stage talks to a stage served in another process by frames of a 4-byte big-endian
+// length followed by the kind and the payload. The client opens with a hello naming the codec,
+// then both sides send items encoded by the codec and end with an end frame, every item is acked
+// once it is taken. At most remoteWindow items of each side may wait for their acks.
+
+const (
+	remoteWindow = 64
+	maxFrameSize = 16 << 20
+	defaultCodec = "gob"
+)
+
+const (
+	frameHello byte = 'h'
+	frameItem  byte = 'i'
+	frameAck   byte = 'a'
+	frameEnd   byte = 'e'
+	frameError byte = 'x'
+)
+
+// Codec encodes the items passed between processes
+type Codec interface {
+	Marshal(value interface{}) ([]byte, error)
+	Unmarshal(data []byte) (interface{}, error)
+}
+
+var codecs = struct {
+	sync.RWMutex
+	byName map[string]Codec
+}{byName: map[string]Codec{defaultCodec: gobCodec{}}}
+
+// RegisterCodec makes the codec available to remote stages under the name
+func RegisterCodec(name string, codec Codec) error {
+	codecs.Lock()
+	defer codecs.Unlock()
+
+	if _, ok := codecs.byName[name]; ok {
+		return fmt.Errorf("codec %s is already registered", name)
+	}
+	codecs.byName[name] = codec
+	return nil
+}
+
+func lookupCodec(name string) (Codec, bool) {
+	codecs.RLock()
+	defer codecs.RUnlock()
+
+	codec, ok := codecs.byName[name]
+	return codec, ok
+}
+
+// gobCodec passes the values of the types known to gob, the other ones need gob.Register
+type gobCodec struct{}
+
+type gobValue struct {
+	Value interface{}
+}
+
+func (gobCodec) Marshal(value interface{}) ([]byte, error) {
+	buf := &bytes.Buffer{}
+	err := gob.NewEncoder(buf).Encode(gobValue{value})
+	return buf.Bytes(), err
+}
+
+func (gobCodec) Unmarshal(data []byte) (interface{}, error) {
+	var v gobValue
+	err := gob.NewDecoder(bytes.NewReader(data)).Decode(&v)
+	return v.Value, err
+}
+
+// WithCodec sets the codec of a remote stage, gob is the default
+func WithCodec(name string) Option {
+	return func(c *config) {
+		c.codec = name
+	}
+}
+
+// framer reads and writes the frames of a connection, writes may come from several goroutines
+type framer struct {
+	r  *bufio.Reader
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func newFramer(conn net.Conn) *framer {
+	return &framer{r: bufio.NewReader(conn), w: conn}
+}
+
+func (f *framer) write(kind byte, payload []byte) error {
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame, uint32(1+len(payload)))
+	frame[4] = kind
+	copy(frame[5:], payload)
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	_, err := f.w.Write(frame)
+	return err
+}
+
+func (f *framer) read() (byte, []byte, error) {
+	var header [4]byte
+	if _, err := io.ReadFull(f.r, header[:]); err != nil {
+		return 0, nil, err
+	}
+
+	size := binary.BigEndian.Uint32(header[:])
+	if size == 0 || size > maxFrameSize {
+		return 0, nil, fmt.Errorf("remote: bad frame size %d", size)
+	}
+
+	frame := make([]byte, size)
+	if _, err := io.ReadFull(f.r, frame); err != nil {
+		return 0, nil, err
+	}
+	return frame[0], frame[1:], nil
+}
+
+// closeOnDone closes the connection or the listener once ctx is done to interrupt blocked calls,
+// the returned function stops watching
+func closeOnDone(ctx context.Context, conn io.Closer) func() {
+	stop := make(chan struct{})
+	go func() {
+		select {
+		case <-ctx.Done():
+			conn.Close()
+		case <-stop:
+		}
+	}()
+	return func() { close(stop) }
+}
+
+// ParseAddress splits an address such as tcp://localhost:7001 or unix:///tmp/signer.sock
+// into the network and the address for net.Dial and net.Listen
+func ParseAddress(address string) (network, addr string, err error) {
+	network, addr, ok := strings.Cut(address, "://")
+	if !ok || addr == "" {
+		return "", "", fmt.Errorf("bad address %s, expected tcp://HOST:PORT or unix://PATH", address)
+	}
+	switch network {
+	case "tcp", "tcp4", "tcp6", "unix":
+		return network, addr, nil
+	}
+	return "", "", fmt.Errorf("bad address %s: unsupported network %s", address, network)
+}
+
+// RemoteStage builds a stage passing its input to the stage served at the address by ServeStage
+// and its results on, it accepts WithName and WithCodec. StageJob runs it in ExecutePipeline.
+// The metrics show it as remote and the address unless it is named. Once the drain timeout of a shutdown passes it drops the connection with the items
+// still there and ends as if its input was over.
+func RemoteStage(network, address string, opts ...Option) stage {
+
+	c := newConfig(config{name: "remote " + address, codec: defaultCodec}, opts)
+
+	return func(ctx context.Context, in, out chan interface{}) error {
+
+		nameStage(ctx, c.name)
+
+		codec, ok := lookupCodec(c.codec)
+		if !ok {
+			return fmt.Errorf("remote: unknown codec %s", c.codec)
+		}
+
+		var dialer net.Dialer
+		conn, err := dialer.DialContext(ctx, network, address)
+		if err != nil {
+			return err
+		}
+		defer conn.Close()
+
+		g := newGroup(ctx)
+		defer closeOnDone(g.ctx, conn)()
+
+		f := newFramer(conn)
+		if err := f.write(frameHello, []byte(c.codec)); err != nil {
+			return err
+		}
+
+		window := newSemaphore(remoteWindow)
+
+		workers := &sync.WaitGroup{}
+		workers.Add(2)
+		abandonOnExpiry(ctx, g, workers)
+
+		// sending the input
+		g.Go(func(ctx context.Context) error {
+			defer workers.Done()
+			for {
+				value, ok, err := takeItem(ctx, in)
+				if err != nil {
+					return err
+				}
+				if !ok {
+					return f.write(frameEnd, nil)
+				}
+
+				payload, err := codec.Marshal(value)
+				if err != nil {
+					return fmt.Errorf("remote: can`t encode %v (%T): %w", value, value, err)
+				}
+				if err := window.acquire(ctx); err != nil {
+					return err
+				}
+				if err := f.write(frameItem, payload); err != nil {
+					return err
+				}
+			}
+		})
+
+		// receiving the results, the server closes the connection after its end
+		g.Go(func(ctx context.Context) error {
+			defer workers.Done()
+			ended := false
+			for {
+				kind, payload, err := f.read()
+				if err == io.EOF && ended {
+					return nil
+				}
+				if err != nil {
+					return err
+				}
+
+				switch kind {
+				case frameAck:
+					window.release()
+
+				case frameItem:
+					value, err := codec.Unmarshal(payload)
+					if err != nil {
+						return fmt.Errorf("remote: can`t decode item: %w", err)
+					}
+					if err := f.write(frameAck, nil); err != nil {
+						return err
+					}
+					if err := passItem(ctx, out, value); err != nil {
+						return err
+					}
+
+				case frameEnd:
+					ended = true
+
+				case frameError:
+					return fmt.Errorf("remote %s: %s", address, payload)
+
+				default:
+					return fmt.Errorf("remote: unexpected frame %q", kind)
+				}
+			}
+		})
+
+		err = g.Wait()
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		if errors.Is(err, errDrainExpired) {
+			return nil
+		}
+		return err
+	}
+}
+
+// ServeStage runs the stage for every connection accepted by the listener until ctx is done,
+// the connection brings the input of the stage and takes its output back
+func ServeStage(ctx context.Context, l net.Listener, s stage) error {
+
+	defer closeOnDone(ctx, l)()
+
+	wg := &sync.WaitGroup{}
+	defer wg.Wait()
+
+	for {
+		conn, err := l.Accept()
+		if err != nil {
+			if ctx.Err() != nil {
+				return nil
+			}
+			return err
+		}
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			serveConn(ctx, conn, s)
+		}()
+	}
+}
+
+// serveConn runs the stage for a single connection
+func serveConn(ctx context.Context, conn net.Conn, s stage) {
+
+	defer conn.Close()
+	defer closeOnDone(ctx, conn)()
+
+	f := newFramer(conn)
+
+	kind, payload, err := f.read()
+	if err != nil {
+		return
+	}
+	if kind != frameHello {
+		f.write(frameError, []byte("expected hello"))
+		return
+	}
+	codec, ok := lookupCodec(string(payload))
+	if !ok {
+		f.write(frameError, []byte("unknown codec "+string(payload)))
+		return
+	}
+
+	g := newGroup(ctx)
+	in, out := make(chan interface{}), make(chan interface{})
+	window := newSemaphore(remoteWindow)
+
+	// the reader never waits for the stage, otherwise the acks of the results would be stuck
+	// in the connection behind an item. An item is acked once the stage takes it, so the client
+	// window bounds the queue.
+	queue := make(chan interface{}, remoteWindow)
+	go func() {
+		for value := range queue {
+			// a stage that is gone leaves the items behind
+			if err := send(g.ctx, in, value); err != nil {
+				break
+			}
+			if err := f.write(frameAck, nil); err != nil {
+				g.fail(err)
+			}
+		}
+		close(in)
+		drain(queue)
+	}()
+
+	// the reader stops once the connection is closed, the client ending its input
+	// does not stop it as the acks of the results are still coming
+	reading, stopReading := context.WithCancel(ctx)
+	ended := make(chan struct{})
+	endInput := &sync.Once{}
+	go func() {
+		defer stopReading()
+		defer endInput.Do(func() { close(queue) })
+
+		for {
+			kind, payload, err := f.read()
+			if err != nil {
+				// the client is gone before the end of its input, its items are not needed anymore
+				g.fail(fmt.Errorf("connection lost: %w", err))
+				return
+			}
+
+			switch kind {
+			case frameAck:
+				window.release()
+
+			case frameItem:
+				value, err := codec.Unmarshal(payload)
+				if err != nil {
+					g.fail(fmt.Errorf("can`t decode item: %w", err))
+					continue
+				}
+				select {
+				case queue <- value:
+				default:
+					g.fail(fmt.Errorf("the client sent more than %d items without acks", remoteWindow))
+					return
+				}
+
+			case frameEnd:
+				endInput.Do(func() { close(queue) })
+				close(ended)
+				for {
+					kind, _, err := f.read()
+					if err != nil {
+						return
+					}
+					if kind == frameAck {
+						window.release()
+					}
+				}
+			}
+		}
+	}()
+
+	launch(g, nil, in, out, func(ctx context.Context) error {
+		return s(ctx, in, out)
+	})
+
+	// sending the results
+	var encodeErr error
+	for value := range out {
+		if encodeErr != nil {
+			continue
+		}
+		payload, err := codec.Marshal(value)
+		if err != nil {
+			encodeErr = fmt.Errorf("can`t encode %v (%T): %w", value, value, err)
+			g.fail(encodeErr)
+			continue
+		}
+		if err := window.acquire(reading); err != nil {
+			return
+		}
+		if err := f.write(frameItem, payload); err != nil {
+			return
+		}
+	}
+
+	if err := g.Wait(); err != nil {
+		// the client closes the connection once it gets the error
+		f.write(frameError, []byte(err.Error()))
+		<-reading.Done()
+		return
+	}
+
+	if err := f.write(frameEnd, nil); err != nil {
+		return
+	}
+
+	// closing only when nothing else is coming from the client
+	select {
+	case <-ended:
+	case <-reading.Done():
+		return
+	}
+	for i := 0; i < remoteWindow; i++ {
+		if err := window.acquire(reading); err != nil {
+			return
+		}
+	}
+}
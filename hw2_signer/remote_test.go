@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// serve runs the stage on the listener until the test is over
+func serve(t *testing.T, l net.Listener, s stage) {
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error, 1)
+	go func() {
+		done <- ServeStage(ctx, l, s)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		if err := <-done; err != nil {
+			t.Errorf("unexpected serve error: %v", err)
+		}
+	})
+}
+
+func TestRemoteStage(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	single, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, single, SingleHashContext)
+
+	multi, err := net.Listen("unix", filepath.Join(t.TempDir(), "multi.sock"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, multi, MultiHashContext)
+
+	var result string
+	err = ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for _, value := range []interface{}{0, 1} {
+				if err := send(ctx, out, value); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		RemoteStage("tcp", single.Addr().String()),
+		RemoteStage("unix", multi.Addr().String()),
+		CombineResultsContext,
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				result = value.(string)
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestRemoteStageWindow(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, func(ctx context.Context, in, out chan interface{}) error {
+		for value := range in {
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	// far more values than the window, so both sides wait for acks while items are coming
+	const values = 5000
+
+	m := &Metrics{}
+	received := 0
+	err = ExecutePipelineContext(RecordMetrics(context.Background(), m),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for i := 0; i < values; i++ {
+				if err := send[interface{}](ctx, out, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		RemoteStage("tcp", l.Addr().String()),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for value := range in {
+				if value != received {
+					return fmt.Errorf("got %v after %d values", value, received)
+				}
+				received++
+			}
+			return nil
+		},
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+	if received != values {
+		t.Errorf("got %d values, expected %d", received, values)
+	}
+
+	if remote := m.Snapshot().Stages[1]; remote.ItemsIn != values || remote.ItemsOut != values {
+		t.Errorf("wrong item counts of the remote stage: %d in, %d out", remote.ItemsIn, remote.ItemsOut)
+	}
+}
+
+func TestRemoteStageJob(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, SingleHashContext)
+
+	var result string
+	_, err = ExecutePipeline(
+		job(func(in, out chan interface{}) {
+			out <- 0
+			out <- 1
+		}),
+		StageJob(RemoteStage("tcp", l.Addr().String())),
+		job(MultiHash),
+		job(CombineResults),
+		job(func(in, out chan interface{}) {
+			result = (<-in).(string)
+		}),
+	)
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555_4958044192186797981418233587017209679042592862002427381542"
+	if result != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+
+	// the error of the stage fails the run
+	_, err = ExecutePipeline(
+		job(func(in, out chan interface{}) {}),
+		StageJob(RemoteStage("tcp", l.Addr().String(), WithCodec("xml"))),
+		job(func(in, out chan interface{}) {}),
+	)
+	if err == nil || err.Error() != "remote: unknown codec xml" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestRemoteStageError(t *testing.T) {
+
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	serve(t, l, func(ctx context.Context, in, out chan interface{}) error {
+		for value := range in {
+			if value == 3 {
+				return errors.New("bad value 3")
+			}
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	count := func(ctx context.Context, in, out chan interface{}) error {
+		for range in {
+		}
+		return nil
+	}
+
+	// plenty of values, so the window fills up before the failure
+	err = ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for i := 0; i < 1000; i++ {
+				if err := send[interface{}](ctx, out, i); err != nil {
+					return err
+				}
+			}
+			return nil
+		},
+		RemoteStage("tcp", l.Addr().String()),
+		count,
+	)
+
+	expected := "remote " + l.Addr().String() + ": bad value 3"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, expected)
+	}
+
+	err = ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error { return nil },
+		RemoteStage("tcp", l.Addr().String(), WithCodec("xml")),
+		count,
+	)
+	if err == nil || err.Error() != "remote: unknown codec xml" {
+		t.Errorf("wrong error: %v", err)
+	}
+}
+
+func TestParseAddress(t *testing.T) {
+
+	network, addr, err := ParseAddress("unix:///tmp/signer.sock")
+	if err != nil || network != "unix" || addr != "/tmp/signer.sock" {
+		t.Errorf("wrong address: %s %s %v", network, addr, err)
+	}
+
+	if _, _, err := ParseAddress("localhost:7001"); err == nil || !strings.HasPrefix(err.Error(), "bad address") {
+		t.Errorf("wrong error: %v", err)
+	}
+}
@@ -0,0 +1,146 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"time"
+)
+
+// ErrItemTimeout is the cause of an ItemError for an item that was not processed in time
+var ErrItemTimeout = errors.New("item timed out")
+
+// RetryPolicy says how a stage processes an item again after a failure.
+// An item failing its only attempt is handled as without a policy.
+type RetryPolicy struct {
+	Attempts   int           // the number of attempts including the first one
+	Backoff    time.Duration // the pause after the first failure, it doubles after every next one
+	MaxBackoff time.Duration // the longest pause, 0 means no bound
+	Jitter     float64       // the share of the pause taken away at random, from 0 to 1
+	Retryable  func(err error) bool
+}
+
+// ItemError is the error of an item that exhausted its attempts or its time,
+// it fails the pipeline instead of going to the dead letters
+type ItemError struct {
+	Stage    string
+	Item     interface{}
+	Attempts int
+	Err      error
+}
+
+func (e *ItemError) Error() string {
+	return fmt.Sprintf("%s: item %v failed after %d attempts: %v", e.Stage, e.Item, e.Attempts, e.Err)
+}
+
+func (e *ItemError) Unwrap() error {
+	return e.Err
+}
+
+// retryable tells if the error is worth another attempt, every error is unless the policy says otherwise
+func (p RetryPolicy) retryable(err error) bool {
+	return p.Retryable == nil || p.Retryable(err)
+}
+
+// backoff returns the pause after the failed attempt
+func (p RetryPolicy) backoff(attempt int) time.Duration {
+	d := p.Backoff
+	for i := 1; i < attempt && (p.MaxBackoff == 0 || d < p.MaxBackoff); i++ {
+		d *= 2
+	}
+	if p.MaxBackoff > 0 && d > p.MaxBackoff {
+		d = p.MaxBackoff
+	}
+	if p.Jitter > 0 {
+		d -= time.Duration(p.Jitter * rand.Float64() * float64(d))
+	}
+	return d
+}
+
+// withRetries applies the retry policy and the item timeout of the stage to process.
+// An attempt that outlives the timeout is abandoned, so a hanging call does not block the pool.
+func withRetries[In, Out any](c config, process func(ctx context.Context, value In) (Out, error)) func(ctx context.Context, value In) (Out, error) {
+
+	if c.retry == nil && c.timeout <= 0 {
+		return process
+	}
+
+	policy := RetryPolicy{Attempts: 1}
+	if c.retry != nil {
+		policy = *c.retry
+	}
+
+	return func(ctx context.Context, value In) (Out, error) {
+
+		clock := clockFrom(ctx)
+
+		itemCtx := ctx
+		if c.timeout > 0 {
+			var cancel context.CancelFunc
+			itemCtx, cancel = context.WithCancel(ctx)
+			defer cancel()
+			defer clock.AfterFunc(c.timeout, cancel)()
+		}
+
+		var zero Out
+		for attempt := 1; ; attempt++ {
+
+			result, err := attemptOnce(itemCtx, c.timeout > 0, value, process)
+			if err == nil {
+				return result, nil
+			}
+
+			// the pipeline is cancelled, there is nothing to report
+			if ctx.Err() != nil {
+				return zero, ctx.Err()
+			}
+
+			if itemCtx.Err() != nil {
+				err = fmt.Errorf("%w after %s", ErrItemTimeout, c.timeout)
+				return zero, &ItemError{Stage: c.name, Item: value, Attempts: attempt, Err: err}
+			}
+
+			if !policy.retryable(err) {
+				return zero, err
+			}
+			if attempt >= policy.Attempts {
+				if attempt == 1 {
+					return zero, err
+				}
+				return zero, &ItemError{Stage: c.name, Item: value, Attempts: attempt, Err: err}
+			}
+
+			sleepContext(itemCtx, clock, policy.backoff(attempt))
+		}
+	}
+}
+
+// attemptOnce processes the value once, an abandonable attempt runs aside and is left behind once ctx is done
+func attemptOnce[In, Out any](ctx context.Context, abandonable bool, value In, process func(ctx context.Context, value In) (Out, error)) (Out, error) {
+
+	var result Out
+	call := func() error {
+		return safely(func() (err error) {
+			result, err = process(ctx, value)
+			return err
+		})
+	}
+
+	if !abandonable {
+		return result, call()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- call()
+	}()
+
+	select {
+	case err := <-done:
+		return result, err
+	case <-ctx.Done():
+		var zero Out
+		return zero, ctx.Err()
+	}
+}
@@ -0,0 +1,124 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestRetry(t *testing.T) {
+
+	var calls int32
+	flaky := func(ctx context.Context, value int) (int, error) {
+		if atomic.AddInt32(&calls, 1)%3 != 0 {
+			return 0, errors.New("unavailable")
+		}
+		return value, nil
+	}
+
+	policy := RetryPolicy{Attempts: 3, Backoff: time.Millisecond, Jitter: 0.5}
+
+	var result []int
+	chain := Then(From(numbers(1)), Map(flaky, WithRetry(policy)))
+	if err := chain.Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if len(result) != 1 || calls != 3 {
+		t.Errorf("wrong result %v after %d calls", result, calls)
+	}
+}
+
+func TestRetryExhausted(t *testing.T) {
+
+	var calls int32
+	broken := func(ctx context.Context, value int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		panic("unavailable")
+	}
+
+	// the item fails the pipeline even though there are dead letters to collect it
+	ctx := CollectDeadLetters(context.Background(), &DeadLetters{})
+
+	var result []int
+	chain := Then(From(numbers(1)), Map(broken, WithName("remote"), WithRetry(RetryPolicy{Attempts: 4})))
+	err := chain.Run(ctx, collect(&result))
+
+	var itemErr *ItemError
+	if !errors.As(err, &itemErr) || itemErr.Attempts != 4 || itemErr.Stage != "remote" || calls != 4 {
+		t.Fatalf("wrong error after %d calls: %v", calls, err)
+	}
+
+	expected := "remote: item 0 failed after 4 attempts: panic: unavailable"
+	if err.Error() != expected {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, expected)
+	}
+}
+
+func TestRetryNotRetryable(t *testing.T) {
+
+	var calls int32
+	invalid := errors.New("invalid")
+	failing := func(ctx context.Context, value int) (int, error) {
+		atomic.AddInt32(&calls, 1)
+		return 0, invalid
+	}
+
+	policy := RetryPolicy{Attempts: 5, Retryable: func(err error) bool { return !errors.Is(err, invalid) }}
+
+	d := &DeadLetters{}
+	var result []int
+	chain := Then(From(numbers(1)), Map(failing, WithRetry(policy)))
+	if err := chain.Run(CollectDeadLetters(context.Background(), d), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if calls != 1 || len(d.Letters()) != 1 {
+		t.Errorf("wrong handling: %d calls, %d dead letters", calls, len(d.Letters()))
+	}
+}
+
+func TestItemTimeout(t *testing.T) {
+
+	// a remote signer that never answers
+	hang := make(chan struct{})
+	defer close(hang)
+	remote := func(ctx context.Context, value int) (int, error) {
+		<-hang
+		return value, nil
+	}
+
+	start := time.Now()
+	err := Then(From(numbers(3)), Map(remote, WithItemTimeout(50*time.Millisecond))).
+		Run(context.Background(), collect(&[]int{}))
+
+	var itemErr *ItemError
+	if !errors.As(err, &itemErr) || !errors.Is(err, ErrItemTimeout) {
+		t.Fatalf("wrong error: %v", err)
+	}
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("hanging item blocks the pipeline for %s", elapsed)
+	}
+}
+
+func TestRetryBackoff(t *testing.T) {
+
+	policy := RetryPolicy{Backoff: 10 * time.Millisecond, MaxBackoff: 50 * time.Millisecond}
+
+	expected := []time.Duration{10, 20, 40, 50, 50}
+	for i, d := range expected {
+		if got := policy.backoff(i + 1); got != d*time.Millisecond {
+			t.Errorf("wrong backoff after attempt %d\nGot: %s\nExpected: %s", i+1, got, d*time.Millisecond)
+		}
+	}
+
+	policy.Jitter = 1
+	for i := 0; i < 100; i++ {
+		if got := policy.backoff(2); got < 0 || got > 20*time.Millisecond {
+			t.Fatalf("jitter out of range: %s", got)
+		}
+	}
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"context"
+	"crypto/sha1"
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"hash/crc64"
+	"hash/fnv"
+	"io"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+)
+
+// Scheme describes a signature format: the steps are applied one after another,
+// each to the result of the previous one
+type Scheme struct {
+	Name  string `json:"name"`
+	Steps []Step `json:"steps"`
+}
+
+// Step joins the results of its parts, all of them computed from the input of the step
+type Step struct {
+	Parts []Part `json:"parts"`
+	Join  string `json:"join"`
+}
+
+// Part applies registered algorithms to the input, the first algorithm gets the input
+// and every next one the result of the previous. A repeated part is computed Repeat times
+// for the input prefixed with 0..Repeat-1 and the results are joined as separate parts.
+type Part struct {
+	Hashes []string `json:"hashes"`
+	Repeat int      `json:"repeat,omitempty"`
+}
+
+// DefaultScheme is the signature computed by SingleHash and MultiHash
+var DefaultScheme = Scheme{
+	Name: "SingleHash+MultiHash",
+	Steps: []Step{
+		{Parts: []Part{{Hashes: []string{"crc32"}}, {Hashes: []string{"md5", "crc32"}}}, Join: "~"},
+		{Parts: []Part{{Hashes: []string{"crc32"}, Repeat: defaultMultiHashTh}}, Join: ""},
+	},
+}
+
+// ParseScheme reads a scheme in JSON and checks that its algorithms are registered
+func ParseScheme(r io.Reader) (Scheme, error) {
+	var s Scheme
+	if err := json.NewDecoder(r).Decode(&s); err != nil {
+		return Scheme{}, fmt.Errorf("scheme: %w", err)
+	}
+	return s, s.Validate()
+}
+
+// Validate checks that the scheme has steps, that no part is repeated a negative number of times
+// and that its algorithms are registered
+func (s Scheme) Validate() error {
+	if len(s.Steps) == 0 {
+		return fmt.Errorf("scheme %s: no steps", s.Name)
+	}
+	for i, step := range s.Steps {
+		if len(step.Parts) == 0 {
+			return fmt.Errorf("scheme %s: step %d has no parts", s.Name, i)
+		}
+		for _, part := range step.Parts {
+			if len(part.Hashes) == 0 {
+				return fmt.Errorf("scheme %s: step %d has a part without hashes", s.Name, i)
+			}
+			if part.Repeat < 0 {
+				return fmt.Errorf("scheme %s: step %d has a part repeated %d times", s.Name, i, part.Repeat)
+			}
+			for _, name := range part.Hashes {
+				if _, ok := lookupAlgorithm(name); !ok {
+					return fmt.Errorf("scheme %s: unknown algorithm %s", s.Name, name)
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// Sign computes the signature of data
+func (s Scheme) Sign(ctx context.Context, data string) (string, error) {
+	for _, step := range s.Steps {
+		var err error
+		if data, err = step.apply(ctx, data); err != nil {
+			return "", err
+		}
+	}
+	return data, nil
+}
+
+func (step Step) apply(ctx context.Context, data string) (string, error) {
+
+	// the repeated parts are expanded into separate inputs
+	type task struct {
+		part  Part
+		input string
+	}
+	var tasks []task
+	for _, part := range step.Parts {
+		if part.Repeat == 0 {
+			tasks = append(tasks, task{part, data})
+		}
+		for th := 0; th < part.Repeat; th++ {
+			tasks = append(tasks, task{part, strconv.Itoa(th) + data})
+		}
+	}
+
+	results := make([]string, len(tasks))
+	hashes := newGroup(ctx)
+
+	for i, t := range tasks {
+		index, t := i, t
+		hashes.Go(func(ctx context.Context) error {
+			value := t.input
+			for _, name := range t.part.Hashes {
+				a, ok := lookupAlgorithm(name)
+				if !ok {
+					return fmt.Errorf("unknown algorithm %s", name)
+				}
+				var err error
+				if value, err = a(ctx, value); err != nil {
+					return err
+				}
+			}
+			results[index] = value
+			return nil
+		})
+	}
+
+	if err := hashes.Wait(); err != nil {
+		return "", err
+	}
+
+	return strings.Join(results, step.Join), nil
+}
+
+// NewSchemeStage builds a stage signing the values with the scheme by a pool of workers,
+// it accepts WithName, WithWorkers, WithBuffer, WithOrder, WithRetry and WithItemTimeout
+func NewSchemeStage(s Scheme, opts ...Option) (Stage[string, string], error) {
+
+	if err := s.Validate(); err != nil {
+		return nil, err
+	}
+
+	c := newConfig(config{name: s.Name, workers: defaultWorkers}, opts)
+
+	return func(ctx context.Context, in chan string, out chan string) error {
+		return runPool(ctx, c, in, out, s.Sign)
+	}, nil
+}
+
+// algorithm is a registered signer restricted by its guard
+type algorithm = func(ctx context.Context, data string) (string, error)
+
+var algorithms = struct {
+	sync.RWMutex
+	byName map[string]algorithm
+}{byName: make(map[string]algorithm)}
+
+// RegisterAlgorithm makes the signer available to schemes under the name. At most concurrency
+// calls of it run at once, shared by every scheme, zero or less means no limit.
+func RegisterAlgorithm(name string, signer func(data string) string, concurrency int) error {
+	algorithms.Lock()
+	defer algorithms.Unlock()
+
+	return registerAlgorithm(name, NewGuard(concurrency, 0, nil).Wrap(signer))
+}
+
+// registerAlgorithm makes the signer restricted by its guard available to schemes under the name,
+// it is called with the registry locked
+func registerAlgorithm(name string, a algorithm) error {
+	if _, ok := algorithms.byName[name]; ok {
+		return fmt.Errorf("algorithm %s is already registered", name)
+	}
+	algorithms.byName[name] = a
+	return nil
+}
+
+// Algorithms returns the names of the registered algorithms
+func Algorithms() []string {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+
+	names := make([]string, 0, len(algorithms.byName))
+	for name := range algorithms.byName {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func lookupAlgorithm(name string) (algorithm, bool) {
+	algorithms.RLock()
+	defer algorithms.RUnlock()
+
+	a, ok := algorithms.byName[name]
+	return a, ok
+}
+
+var crc64Table = crc64.MakeTable(crc64.ECMA)
+
+func init() {
+	// the signers are looked up on every call, so they can be replaced like in the tests
+	must := func(err error) {
+		if err != nil {
+			panic(err)
+		}
+	}
+	must(RegisterAlgorithm("crc32", func(data string) string { return DataSignerCrc32(data) }, 0))
+	// md5 shares its guard with SingleHash, as a second call anywhere overheats the signer
+	algorithms.Lock()
+	must(registerAlgorithm("md5", guardedMd5))
+	algorithms.Unlock()
+
+	must(RegisterAlgorithm("sha256", func(data string) string {
+		return fmt.Sprintf("%x", sha256.Sum256([]byte(data+DataSignerSalt)))
+	}, 0))
+	must(RegisterAlgorithm("sha1", func(data string) string {
+		return fmt.Sprintf("%x", sha1.Sum([]byte(data+DataSignerSalt)))
+	}, 0))
+	must(RegisterAlgorithm("fnv", func(data string) string {
+		h := fnv.New64a()
+		h.Write([]byte(data + DataSignerSalt))
+		return strconv.FormatUint(h.Sum64(), 10)
+	}, 0))
+	must(RegisterAlgorithm("crc64", func(data string) string {
+		return strconv.FormatUint(crc64.Checksum([]byte(data+DataSignerSalt), crc64Table), 10)
+	}, 0))
+}
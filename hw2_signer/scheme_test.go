@@ -0,0 +1,83 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestDefaultScheme(t *testing.T) {
+
+	useFastSigners(t, time.Millisecond)
+
+	signature, err := DefaultScheme.Sign(context.Background(), "0")
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	expected := "29568666068035183841425683795340791879727309630931025356555"
+	if signature != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", signature, expected)
+	}
+}
+
+func TestParseScheme(t *testing.T) {
+
+	s, err := ParseScheme(strings.NewReader(`{
+		"name": "digest",
+		"steps": [
+			{"parts": [{"hashes": ["sha256", "crc64"]}, {"hashes": ["fnv"]}, {"hashes": ["sha1"], "repeat": 2}], "join": "-"}
+		]
+	}`))
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	stage, err := NewSchemeStage(s, WithOrder())
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	texts := Then(From(numbers(2)), func(ctx context.Context, in chan int, out chan string) error {
+		for value := range in {
+			if err := send(ctx, out, strconv.Itoa(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	var result []string
+	if err := Then(texts, stage).Run(context.Background(), collect(&result)); err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	// sha1 of 00 and 10 for the repeated part
+	expected := "10134527136643585951-12638135523509116079-" +
+		"fb96549631c835eb239cd614cc6b5cb7d295121a-b1d5781111d84f7b3fe45a0852e59758cd7a87e5"
+	if len(result) != 2 || result[0] != expected {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, expected)
+	}
+}
+
+func TestSchemeValidate(t *testing.T) {
+
+	cases := map[string]string{
+		`{"name": "empty"}`: "scheme empty: no steps",
+		`{"name": "unknown", "steps": [{"parts": [{"hashes": ["sha3"]}]}]}`:                 "scheme unknown: unknown algorithm sha3",
+		`{"name": "bare", "steps": [{"parts": [{}]}]}`:                                      "scheme bare: step 0 has a part without hashes",
+		`{"name": "negative", "steps": [{"parts": [{"hashes": ["crc32"], "repeat": -1}]}]}`: "scheme negative: step 0 has a part repeated -1 times",
+	}
+
+	for definition, expected := range cases {
+		if _, err := ParseScheme(strings.NewReader(definition)); err == nil || err.Error() != expected {
+			t.Errorf("wrong error\nGot: %v\nExpected: %v", err, expected)
+		}
+	}
+
+	if err := RegisterAlgorithm("md5", DataSignerMd5, 1); err == nil {
+		t.Errorf("duplicate algorithm is registered")
+	}
+}
@@ -0,0 +1,223 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/signal"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// ErrIncomplete is returned by a pipeline a shutdown stopped before its sources ended,
+// its CombineResults emitted the result of only the values that made it through
+var ErrIncomplete = errors.New("incomplete result: the pipeline was shut down")
+
+// errDrainExpired ends a worker pool abandoning its items once the drain timeout passes
+var errDrainExpired = errors.New("drain timeout expired")
+
+// Shutdown stops pipelines gracefully: their sources stop producing and the values already
+// in flight are finished. Once the drain timeout passes the worker pools and the remote stages
+// abandon their items and end as if their input was over, so CombineResults still emits what it has.
+// Other stages are left to finish. The timeout is measured by the clock of the pipeline.
+type Shutdown struct {
+	timeout     time.Duration
+	stopOnce    sync.Once
+	expireOnce  sync.Once
+	stopping    chan struct{}
+	expired     chan struct{}
+	interrupted int32 // a source was cut or an item abandoned
+}
+
+// NewShutdown creates a shutdown letting the values in flight drain for at most timeout,
+// zero or less removes the limit
+func NewShutdown(timeout time.Duration) *Shutdown {
+	return &Shutdown{timeout: timeout, stopping: make(chan struct{}), expired: make(chan struct{})}
+}
+
+// ShutdownWith makes the pipeline running with ctx stop gracefully on s
+func ShutdownWith(ctx context.Context, s *Shutdown) context.Context {
+	return context.WithValue(ctx, shutdownKey{}, s)
+}
+
+type shutdownKey struct{}
+
+// shutdownFrom returns the shutdown of ctx or nil
+func shutdownFrom(ctx context.Context) *Shutdown {
+	s, _ := ctx.Value(shutdownKey{}).(*Shutdown)
+	return s
+}
+
+// Stop begins the shutdown, the later calls do nothing
+func (s *Shutdown) Stop() {
+	s.stopOnce.Do(func() {
+		close(s.stopping)
+	})
+}
+
+// expire ends the drain
+func (s *Shutdown) expire() {
+	s.expireOnce.Do(func() {
+		close(s.expired)
+	})
+}
+
+// Notify begins the shutdown on the first of the signals and ends the drain on the second one,
+// the returned function stops listening
+func (s *Shutdown) Notify(signals ...os.Signal) (stop func()) {
+
+	received := make(chan os.Signal, 2)
+	signal.Notify(received, signals...)
+
+	done := make(chan struct{})
+	go func() {
+		for _, next := range []func(){s.Stop, s.expire} {
+			select {
+			case <-received:
+				next()
+			case <-done:
+				return
+			}
+		}
+	}()
+
+	return func() {
+		signal.Stop(received)
+		close(done)
+	}
+}
+
+// Interrupted tells whether a source was cut or an item abandoned by the shutdown
+func (s *Shutdown) Interrupted() bool {
+	return atomic.LoadInt32(&s.interrupted) != 0
+}
+
+func (s *Shutdown) interrupt() {
+	atomic.StoreInt32(&s.interrupted, 1)
+}
+
+// completed returns ErrIncomplete instead of nil for the pipeline running with ctx that was interrupted by its shutdown
+func completed(ctx context.Context, err error) error {
+	if s := shutdownFrom(ctx); err == nil && s != nil && s.Interrupted() {
+		return ErrIncomplete
+	}
+	return err
+}
+
+// stoppable makes the source end its output once the shutdown of ctx begins. The source is cancelled,
+// whatever it still sends is discarded. The relay also measures the values the source hands over an edge
+// without a buffer, which the source can't do itself, the source writes to the edge directly otherwise.
+func stoppable[T any](source Source[T]) Source[T] {
+	return func(ctx context.Context, out chan T) error {
+
+		s := shutdownFrom(ctx)
+		m := measuring(ctx)
+		if m != nil && !m.directOut {
+			m = nil
+		}
+		if s == nil && m == nil {
+			return source(ctx, out)
+		}
+
+		var stopping chan struct{} // a nil channel never stops the source without a shutdown
+		if s != nil {
+			stopping = s.stopping
+		}
+		clock := clockFrom(ctx)
+
+		sourceCtx, cancel := context.WithCancel(nested(ctx))
+		defer cancel()
+
+		values := make(chan T)
+		result := make(chan error, 1)
+		go func() {
+			defer close(values)
+			result <- safely(func() error {
+				return source(sourceCtx, values)
+			})
+		}()
+
+		cut := func() error {
+			s.interrupt()
+			cancel()
+			go drain(values)
+			return nil
+		}
+		cancelled := func() error {
+			cancel()
+			drain(values)
+			return <-result
+		}
+
+		for {
+			select {
+			case value, ok := <-values:
+				if !ok {
+					return <-result
+				}
+				select {
+				case <-stopping:
+					return cut()
+				default:
+				}
+				passed := m.passing(clock)
+				select {
+				case out <- value:
+					passed(true)
+				case <-stopping:
+					passed(false)
+					return cut()
+				case <-ctx.Done():
+					passed(false)
+					return cancelled()
+				}
+			case <-stopping:
+				return cut()
+			case <-ctx.Done():
+				return cancelled()
+			}
+		}
+	}
+}
+
+// abandonOnExpiry fails the group with errDrainExpired once the drain timeout of ctx passes
+// unless the workers are done first. The timeout starts when the shutdown begins and is measured
+// by the clock of ctx.
+func abandonOnExpiry(ctx context.Context, g *group, workers *sync.WaitGroup) {
+
+	s := shutdownFrom(ctx)
+	if s == nil {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		workers.Wait()
+		close(done)
+	}()
+
+	g.Go(func(ctx context.Context) error {
+		select {
+		case <-s.stopping:
+		case <-done:
+			return nil
+		case <-ctx.Done():
+			return nil
+		}
+
+		if s.timeout > 0 {
+			stop := clockFrom(ctx).AfterFunc(s.timeout, s.expire)
+			defer stop()
+		}
+
+		select {
+		case <-s.expired:
+			s.interrupt()
+			return errDrainExpired
+		case <-done:
+		case <-ctx.Done():
+		}
+		return nil
+	})
+}
@@ -0,0 +1,194 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"net"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownDrain(t *testing.T) {
+
+	useFastSigners(t, 5*time.Millisecond)
+
+	shutdown := NewShutdown(0)
+	ctx := ShutdownWith(context.Background(), shutdown)
+
+	// the source would run forever, it stops the pipeline after five values
+	source := func(ctx context.Context, out chan int) error {
+		for i := 0; ; i++ {
+			if i == 5 {
+				shutdown.Stop()
+			}
+			if err := send(ctx, out, i); err != nil {
+				return err
+			}
+		}
+	}
+
+	var result []string
+	chain := Then(Then(Then(From(source), NewSingleHash()), NewMultiHash()), NewCombineResults())
+	err := chain.Run(ctx, collect(&result))
+	if !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("wrong error\nGot: %v\nExpected: %v", err, ErrIncomplete)
+	}
+
+	// the value taken along with the stop may be left out
+	if len(result) != 1 {
+		t.Fatalf("no partial result: %v", result)
+	}
+	if hashes := strings.Count(result[0], "_") + 1; hashes < 4 || hashes > 5 {
+		t.Errorf("values in flight are not finished: %d hashes in %s", hashes, result[0])
+	}
+	if !shutdown.Interrupted() {
+		t.Errorf("shutdown is not reported")
+	}
+}
+
+func TestShutdownDrainTimeout(t *testing.T) {
+
+	release := make(chan struct{})
+	defer close(release)
+
+	// the third value hangs and is abandoned once the drain timeout passes
+	stuck := make(chan struct{})
+	process := func(ctx context.Context, value int) (string, error) {
+		if value == 2 {
+			close(stuck)
+			<-release
+		}
+		return strconv.Itoa(value), nil
+	}
+
+	shutdown := NewShutdown(50 * time.Millisecond)
+	m := &Metrics{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(RecordMetrics(ShutdownWith(context.Background(), shutdown), m), clock)
+
+	source := func(ctx context.Context, out chan int) error {
+		if err := numbers(3)(ctx, out); err != nil {
+			return err
+		}
+		// stopping along with the last value could leave it out
+		<-stuck
+		shutdown.Stop()
+		<-ctx.Done()
+		return ctx.Err()
+	}
+
+	var result []string
+	errc := make(chan error)
+	go func() {
+		chain := Then(Then(From(source), Map(process, WithWorkers(3))), NewCombineResults())
+		errc <- chain.Run(ctx, collect(&result))
+	}()
+
+	// the timeout passes once the other values reached CombineResults
+	waitItems(m, 2, 2)
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+
+	if err := <-errc; !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("wrong error\nGot: %v\nExpected: %v", err, ErrIncomplete)
+	}
+	if len(result) != 1 || result[0] != "0_1" {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, "[0_1]")
+	}
+}
+
+func TestShutdownDrainTimeoutRemote(t *testing.T) {
+
+	// the worker hangs on the third value until the connection is dropped
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	stuck := make(chan struct{})
+	serve(t, l, func(ctx context.Context, in, out chan interface{}) error {
+		for value := range in {
+			if value == "2" {
+				close(stuck)
+				<-ctx.Done()
+				return ctx.Err()
+			}
+			if err := send(ctx, out, value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	shutdown := NewShutdown(50 * time.Millisecond)
+	m := &Metrics{}
+	clock := NewFakeClock(time.Time{})
+	ctx := UseClock(RecordMetrics(ShutdownWith(context.Background(), shutdown), m), clock)
+
+	var result string
+	errc := make(chan error)
+	go func() {
+		errc <- ExecutePipelineContext(ctx,
+			func(ctx context.Context, in, out chan interface{}) error {
+				for _, value := range []interface{}{"0", "1", "2"} {
+					if err := send(ctx, out, value); err != nil {
+						return err
+					}
+				}
+				<-stuck
+				shutdown.Stop()
+				<-ctx.Done()
+				return ctx.Err()
+			},
+			RemoteStage("tcp", l.Addr().String()),
+			Untyped("CombineResults", NewCombineResults()),
+			func(ctx context.Context, in, out chan interface{}) error {
+				for value := range in {
+					result = value.(string)
+				}
+				return nil
+			},
+		)
+	}()
+
+	waitItems(m, 2, 2)
+	clock.BlockUntil(1)
+	clock.Advance(50 * time.Millisecond)
+
+	if err := <-errc; !errors.Is(err, ErrIncomplete) {
+		t.Fatalf("wrong error\nGot: %v\nExpected: %v", err, ErrIncomplete)
+	}
+	if result != "0_1" {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", result, "0_1")
+	}
+}
+
+// waitItems waits until the stage of the run at the position took n items
+func waitItems(m *Metrics, stage int, n int64) {
+	for {
+		if stages := m.Snapshot().Stages; len(stages) > stage && stages[stage].ItemsIn >= n {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestShutdownNotify(t *testing.T) {
+
+	shutdown := NewShutdown(0)
+	defer shutdown.Notify(syscall.SIGUSR1)()
+
+	// the first signal stops the sources, the second one ends the drain
+	for _, done := range []chan struct{}{shutdown.stopping, shutdown.expired} {
+		if err := syscall.Kill(syscall.Getpid(), syscall.SIGUSR1); err != nil {
+			t.Fatal(err)
+		}
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+			t.Fatalf("signal is not handled")
+		}
+	}
+}
@@ -1,154 +1,294 @@
 package main
 
 import (
-	"sort"
+	"context"
+	"fmt"
+	"os"
 	"strconv"
 	"strings"
 	"sync"
 )
 
 func main() {
-	// functions are triggered by tests
+	if err := runSigner(os.Stdin, os.Stdout, os.Stderr, os.Args[1:]); err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
 }
 
-// ExecutePipeline runs job functions as a pipeline
-func ExecutePipeline(jobs ...job) {
-
-	if len(jobs) < 2 {
-		return
-	}
+// ExecutePipeline runs job functions as a pipeline. The items SingleHash, MultiHash and CombineResults
+// reject are collected for the run and returned once it is over along with the first error of the run.
+func ExecutePipeline(jobs ...job) (*DeadLetters, error) {
 
-	channels := make([]chan interface{}, len(jobs)-1)
-	for i := range channels {
-		channels[i] = make(chan interface{})
+	names := make([]string, len(jobs))
+	stages := make([]stage, len(jobs))
+	for i, j := range jobs {
+		names[i] = funcName(j)
+		stages[i] = jobStage(j)
 	}
 
-	wg := &sync.WaitGroup{}
-	wg.Add(len(jobs))
-
-	go func() {
-		jobs[0](nil, channels[0])
-		wg.Done()
-		close(channels[0])
-	}()
-
-	for i := 1; i < len(jobs)-1; i++ {
-		go func(i int) {
-			jobs[i](channels[i-1], channels[i])
-			wg.Done()
-			close(channels[i])
-		}(i)
-	}
+	dead := &DeadLetters{}
+	err := executePipeline(CollectDeadLetters(context.Background(), dead), names, stages)
+	return dead, err
+}
 
-	go func() {
-		jobs[len(jobs)-1](channels[len(channels)-1], nil)
-		wg.Done()
-	}()
+// jobRun is what a job of the package needs from the run of ExecutePipeline it belongs to
+type jobRun struct {
+	ctx context.Context
+	mu  sync.Mutex
+	err error
+}
 
-	wg.Wait()
+// jobChannels identify the job a stage of ExecutePipeline runs, the channels belong to it only
+type jobChannels struct {
+	in, out chan interface{}
 }
 
-var (
-	quota       = make(chan struct{}, 1)
-	workerCount = 7
-)
+// jobRuns are the runs of the jobs running in ExecutePipeline
+var jobRuns = struct {
+	sync.Mutex
+	byChannels map[jobChannels]*jobRun
+}{byChannels: make(map[jobChannels]*jobRun)}
 
-func SingleHash(in, out chan interface{}) {
+// jobStage adapts a job that knows nothing about cancellation and errors. The jobs of the package
+// find the run by the channels they are given, so they collect its dead letters, stop with it
+// and fail it even when another job calls them.
+func jobStage(j job) stage {
+	return func(ctx context.Context, in, out chan interface{}) error {
 
-	singleHashWG := &sync.WaitGroup{}
-	singleHashWG.Add(workerCount)
+		key := jobChannels{in, out}
+		run := &jobRun{ctx: ctx}
 
-	// starting a worker pool
-	for i := 0; i < workerCount; i++ {
-		go func(in, out chan interface{}, singleHashWG *sync.WaitGroup) {
-			defer singleHashWG.Done()
+		jobRuns.Lock()
+		jobRuns.byChannels[key] = run
+		jobRuns.Unlock()
 
-			// processing incoming data
-			for source := range in {
-				intValue, ok := source.(int)
-				if !ok {
-					panic("can`t convert source to int")
-				}
+		defer func() {
+			jobRuns.Lock()
+			delete(jobRuns.byChannels, key)
+			jobRuns.Unlock()
+		}()
 
-				data := strconv.Itoa(intValue)
+		j(in, out)
 
-				parts := make([]string, 2)
-				channel := make(chan string, 1)
+		run.mu.Lock()
+		defer run.mu.Unlock()
+		return run.err
+	}
+}
+
+// StageJob adapts a stage, such as a RemoteStage, to a job of ExecutePipeline. In ExecutePipeline
+// the stage runs in the context of the run and fails it with its error, outside of it the error panics.
+func StageJob(s Stage[interface{}, interface{}]) job {
+	return func(in, out chan interface{}) {
+		runJob(s, in, out)
+	}
+}
 
-				go func() {
-					channel <- DataSignerCrc32(data)
-				}()
+// runJob runs the context version of a job of the package in the run of ExecutePipeline owning the channels,
+// the job fails the run with its error. Outside of ExecutePipeline the error panics.
+func runJob(s stage, in, out chan interface{}) {
 
-				quota <- struct{}{}
-				md5 := DataSignerMd5(data)
-				<-quota
+	jobRuns.Lock()
+	run := jobRuns.byChannels[jobChannels{in, out}]
+	jobRuns.Unlock()
 
-				parts[1] = DataSignerCrc32(md5)
-				parts[0] = <-channel
+	if run == nil {
+		if err := s(context.Background(), in, out); err != nil {
+			panic(err.Error())
+		}
+		return
+	}
 
-				out <- strings.Join(parts, "~")
-			}
-		}(in, out, singleHashWG)
+	if err := s(run.ctx, in, out); err != nil {
+		run.mu.Lock()
+		if run.err == nil {
+			run.err = err
+		}
+		run.mu.Unlock()
 	}
+}
 
-	singleHashWG.Wait()
+// SingleHash run outside of ExecutePipeline panics on a bad item
+func SingleHash(in, out chan interface{}) {
+	runJob(SingleHashContext, in, out)
 }
 
-const multiHashTh = 6
+// SingleHashContext is SingleHash that stops on cancellation and reports bad input as an error
+func SingleHashContext(ctx context.Context, in, out chan interface{}) error {
+	return Untyped("SingleHash", SingleHashStage)(ctx, in, out)
+}
 
-func MultiHash(in, out chan interface{}) {
+// defaultSingleHash is shared by every pipeline using SingleHash
+var defaultSingleHash = NewSingleHash()
 
-	multiHashWG := &sync.WaitGroup{}
-	multiHashWG.Add(workerCount)
+// SingleHashStage is the typed SingleHash for chains built with From and Then
+func SingleHashStage(ctx context.Context, in chan int, out chan string) error {
+	return defaultSingleHash(ctx, in, out)
+}
 
-	// starting a worker pool
-	for i := 0; i < workerCount; i++ {
-		go func(in, out chan interface{}, multiHashWG *sync.WaitGroup) {
-			defer multiHashWG.Done()
+// md5Guard lets a single DataSignerMd5 call run at a time in the whole process, a second one overheats
+// the signer and waits for a second. Every SingleHash and the md5 of the schemes go through it.
+var md5Guard = NewGuard(1, 0, nil)
+
+// guardedMd5 is DataSignerMd5 restricted by md5Guard
+var guardedMd5 = md5Guard.Wrap(func(data string) string {
+	return DataSignerMd5(data)
+})
+
+// NewSingleHash builds a SingleHash stage with its own worker pool, its md5 calls are restricted
+// by the process-wide md5 guard and further by its own limit and rate
+func NewSingleHash(opts ...Option) Stage[int, string] {
+
+	c := newConfig(config{name: "SingleHash", workers: defaultWorkers}, opts)
+	crc32Cache, md5Cache := c.cache.caches()
+	crc32 := cached(crc32Cache, func(ctx context.Context, data string) (string, error) {
+		return DataSignerCrc32(data), nil
+	})
+	guard := NewGuard(c.limit, c.rate, c.clock)
+	md5 := cached(md5Cache, func(ctx context.Context, data string) (string, error) {
+		if err := guard.Acquire(ctx); err != nil {
+			return "", err
+		}
+		defer guard.Release()
 
-			// processing incoming data
-			for source := range in {
-				data, ok := source.(string)
-				if !ok {
-					panic("can`t convert source to string")
-				}
+		return guardedMd5(ctx, data)
+	})
+
+	return func(ctx context.Context, in chan int, out chan string) error {
+		return runPool(ctx, c, in, out, func(ctx context.Context, number int) (string, error) {
 
-				wg := &sync.WaitGroup{}
-				wg.Add(multiHashTh)
+			data := strconv.Itoa(number)
 
-				var parts [multiHashTh]string
+			var parts [2]string
+			var md5Hash string
+			hashes := newGroup(ctx)
 
-				for i := range parts {
-					go func(index int) {
-						defer wg.Done()
-						parts[index] = DataSignerCrc32(strconv.Itoa(index) + data)
-					}(i)
+			hashes.Go(func(ctx context.Context) error {
+				hash, err := crc32(ctx, data)
+				parts[0] = hash
+				return err
+			})
+
+			hashes.Go(func(ctx context.Context) error {
+				hash, err := md5(ctx, data)
+				if err != nil {
+					return err
 				}
+				md5Hash = hash
+
+				parts[1], err = crc32(ctx, hash)
+				return err
+			})
 
-				wg.Wait()
+			if err := hashes.Wait(); err != nil {
+				return "", err
+			}
 
-				out <- strings.Join(parts[:], "")
+			result := strings.Join(parts[:], "~")
+			if c.trace != nil {
+				trace(c.trace,
+					fmt.Sprintf("%s SingleHash data %s", data, data),
+					fmt.Sprintf("%s SingleHash md5(data) %s", data, md5Hash),
+					fmt.Sprintf("%s SingleHash crc32(md5(data)) %s", data, parts[1]),
+					fmt.Sprintf("%s SingleHash crc32(data) %s", data, parts[0]),
+					fmt.Sprintf("%s SingleHash result %s", data, result),
+				)
 			}
-		}(in, out, multiHashWG)
+
+			return result, nil
+		})
 	}
+}
 
-	multiHashWG.Wait()
+// MultiHash run outside of ExecutePipeline panics on a bad item
+func MultiHash(in, out chan interface{}) {
+	runJob(MultiHashContext, in, out)
 }
 
-func CombineResults(in, out chan interface{}) {
+// MultiHashContext is MultiHash that stops on cancellation and reports bad input as an error
+func MultiHashContext(ctx context.Context, in, out chan interface{}) error {
+	return Untyped("MultiHash", MultiHashStage)(ctx, in, out)
+}
 
-	var hashes []string
+var defaultMultiHash = NewMultiHash()
 
-	for source := range in {
-		value, ok := source.(string)
-		if !ok {
-			panic("can`t convert source to string")
-		}
+// MultiHashStage is the typed MultiHash for chains built with From and Then
+func MultiHashStage(ctx context.Context, in chan string, out chan string) error {
+	return defaultMultiHash(ctx, in, out)
+}
+
+// NewMultiHash builds a MultiHash stage with its own worker pool and crc32 guard
+func NewMultiHash(opts ...Option) Stage[string, string] {
+
+	c := newConfig(config{name: "MultiHash", workers: defaultWorkers, th: defaultMultiHashTh}, opts)
+	c.params = "th=" + strconv.Itoa(c.th)
+	crc32Cache, _ := c.cache.caches()
+	crc32 := cached(crc32Cache, NewGuard(c.limit, c.rate, c.clock).Wrap(func(data string) string {
+		return DataSignerCrc32(data)
+	}))
+
+	return func(ctx context.Context, in chan string, out chan string) error {
+		return runPool(ctx, c, in, out, func(ctx context.Context, data string) (string, error) {
+
+			parts := make([]string, c.th)
+			hashes := newGroup(ctx)
+
+			for i := range parts {
+				index := i
+				hashes.Go(func(ctx context.Context) error {
+					hash, err := crc32(ctx, strconv.Itoa(index)+data)
+					parts[index] = hash
+					return err
+				})
+			}
+
+			if err := hashes.Wait(); err != nil {
+				return "", err
+			}
 
-		hashes = append(hashes, value)
+			result := strings.Join(parts, "")
+			if c.trace != nil {
+				lines := make([]string, 0, len(parts)+1)
+				for th, part := range parts {
+					lines = append(lines, fmt.Sprintf("%s MultiHash: crc32(th+step1)) %d %s", data, th, part))
+				}
+				trace(c.trace, append(lines, fmt.Sprintf("%s MultiHash result: %s", data, result))...)
+			}
+
+			return result, nil
+		})
+	}
+}
+
+// cached returns signer memoized by the cache, a nil cache leaves it as it is.
+// The signers append DataSignerSalt to the data, so the results are keyed by the salt as well.
+func cached(cache *Cache, signer func(ctx context.Context, data string) (string, error)) func(ctx context.Context, data string) (string, error) {
+	if cache == nil {
+		return signer
+	}
+	return func(ctx context.Context, data string) (string, error) {
+		key := "salt=" + strconv.Quote(DataSignerSalt) + " " + data
+		return cache.Do(ctx, key, func(ctx context.Context) (string, error) {
+			return signer(ctx, data)
+		})
 	}
+}
+
+// CombineResults joins any number of hashes, MaxInputDataLen of common.go does not limit them anymore:
+// the hashes beyond the memory budget are spilled to disk. Run outside of ExecutePipeline it panics on a bad item.
+func CombineResults(in, out chan interface{}) {
+	runJob(CombineResultsContext, in, out)
+}
+
+// CombineResultsContext is CombineResults that stops on cancellation and reports bad input as an error
+func CombineResultsContext(ctx context.Context, in, out chan interface{}) error {
+	return Untyped("CombineResults", CombineResultsStage)(ctx, in, out)
+}
 
-	sort.StringSlice(hashes).Sort()
+var defaultCombineResults = NewCombineResults()
 
-	out <- strings.Join(hashes, "_")
+// CombineResultsStage is the typed CombineResults for chains built with From and Then
+func CombineResultsStage(ctx context.Context, in chan string, out chan string) error {
+	return defaultCombineResults(ctx, in, out)
 }
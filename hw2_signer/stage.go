@@ -0,0 +1,207 @@
+package main
+
+import (
+	"context"
+	"fmt"
+)
+
+// Source produces the values of a pipeline
+type Source[Out any] func(ctx context.Context, out chan Out) error
+
+// Stage turns values of one type into values of another
+type Stage[In, Out any] func(ctx context.Context, in chan In, out chan Out) error
+
+// Sink consumes the values at the end of a pipeline
+type Sink[In any] func(ctx context.Context, in chan In) error
+
+// Chain is a typed pipeline under construction whose last stage emits values of type T.
+// Stages are chained with Then, so a stage that does not accept T does not compile.
+type Chain[T any] struct {
+	start func(r *run, next edgeConfig) (chan T, *StageMetrics) // next is the edge the output goes to
+}
+
+// run is a single execution of a chain
+type run struct {
+	g       *group
+	metrics *Metrics
+}
+
+// From begins a chain with a source
+func From[T any](source Source[T]) *Chain[T] {
+	return from(funcName(source), source)
+}
+
+func from[T any](name string, source Source[T]) *Chain[T] {
+	return &Chain[T]{
+		start: func(r *run, next edgeConfig) (chan T, *StageMetrics) {
+			m := r.metrics.addStage(name)
+			m.directOut = next.buffer == 0
+			out := make(chan T)
+			launch[struct{}](r.g, m, nil, out, func(ctx context.Context) error {
+				return stoppable(source)(ctx, out)
+			})
+			return out, m
+		},
+	}
+}
+
+// Then appends a stage to the chain, the options configure the edge between the stage and the previous one
+func Then[In, Out any](c *Chain[In], s Stage[In, Out], opts ...EdgeOption) *Chain[Out] {
+	return then(c, funcName(s), s, opts...)
+}
+
+func then[In, Out any](c *Chain[In], name string, s Stage[In, Out], opts ...EdgeOption) *Chain[Out] {
+	return &Chain[Out]{
+		start: func(r *run, next edgeConfig) (chan Out, *StageMetrics) {
+			config := newEdgeConfig(opts)
+			previous, previousMetrics := c.start(r, config)
+			m := r.metrics.addStage(name)
+			m.directIn, m.directOut = config.buffer == 0, next.buffer == 0
+			e := r.metrics.addEdge(previousMetrics, m, config)
+			in := connect(r.g, previousMetrics, m, e, previous)
+			out := make(chan Out)
+			launch(r.g, m, in, out, func(ctx context.Context) error {
+				return s(ctx, in, out)
+			})
+			return out, m
+		},
+	}
+}
+
+// Run executes the chain ending with sink, it returns the first error of any stage.
+// The options configure the edge between the sink and the last stage.
+func (c *Chain[T]) Run(ctx context.Context, sink Sink[T], opts ...EdgeOption) error {
+	return c.run(ctx, funcName(sink), sink, opts...)
+}
+
+func (c *Chain[T]) run(ctx context.Context, name string, sink Sink[T], opts ...EdgeOption) error {
+
+	r := &run{g: newGroup(ctx), metrics: metricsFor(ctx)}
+	defer r.metrics.finish()
+
+	config := newEdgeConfig(opts)
+	previous, previousMetrics := c.start(r, config)
+	m := r.metrics.addStage(name)
+	m.directIn = config.buffer == 0
+	e := r.metrics.addEdge(previousMetrics, m, config)
+	in := connect(r.g, previousMetrics, m, e, previous)
+	launch[T, struct{}](r.g, m, in, nil, func(ctx context.Context) error {
+		return measured(sink)(ctx, in)
+	})
+
+	return completed(ctx, r.g.Wait())
+}
+
+// measured runs the sink behind a relay measuring the values it takes over an edge without a buffer,
+// which the sink can't do itself. The sink reads the edge directly otherwise.
+func measured[T any](sink Sink[T]) Sink[T] {
+	return func(ctx context.Context, in chan T) error {
+
+		if m := measuring(ctx); m == nil || !m.directIn {
+			return sink(ctx, in)
+		}
+
+		g := newGroup(ctx)
+		values := make(chan T)
+
+		launch[T](g, nil, in, values, func(ctx context.Context) error {
+			for {
+				value, ok, err := takeItem(ctx, in)
+				if err != nil || !ok {
+					return err
+				}
+				if err := send(ctx, values, value); err != nil {
+					return err
+				}
+			}
+		})
+		launch[T, struct{}](g, nil, values, nil, func(ctx context.Context) error {
+			return sink(nested(ctx), values)
+		})
+
+		return g.Wait()
+	}
+}
+
+// launch starts a stage in the group, closes its output when it returns and drains its input.
+// The stage gets its metrics through ctx unless they are nil.
+func launch[In, Out any](g *group, m *StageMetrics, in chan In, out chan Out, run func(ctx context.Context) error) {
+	g.Go(func(ctx context.Context) error {
+		if m != nil {
+			ctx = context.WithValue(ctx, stageMetricsKey{}, m)
+		}
+
+		err := safely(func() error {
+			return run(ctx)
+		})
+
+		// the rest of the pipeline is cancelled before waiting for anything
+		g.fail(err)
+
+		if out != nil {
+			close(out)
+		}
+
+		// the previous stage must not get stuck sending to a stage that is gone
+		drain(in)
+
+		return err
+	})
+}
+
+// Untyped adapts a typed stage to channels of interface{}. A value of another type
+// goes to the dead letters of ctx if there are any, otherwise it fails the stage.
+func Untyped[In, Out any](name string, s Stage[In, Out]) Stage[interface{}, interface{}] {
+	return func(ctx context.Context, in, out chan interface{}) error {
+
+		nameStage(ctx, name)
+		g := newGroup(ctx)
+
+		typedIn := make(chan In)
+		typedOut := make(chan Out)
+
+		// converting the input
+		launch[interface{}, In](g, nil, nil, typedIn, func(ctx context.Context) error {
+			for {
+				source, ok, err := takeItem(ctx, in)
+				if err != nil || !ok {
+					return err
+				}
+
+				value, ok := source.(In)
+				if !ok {
+					var zero In
+					err := fmt.Errorf("%s: can`t convert source %v (%T) to %T", name, source, source, zero)
+					if err := reject(ctx, name, source, err); err != nil {
+						return err
+					}
+					continue
+				}
+
+				if err := send(ctx, typedIn, value); err != nil {
+					return err
+				}
+			}
+		})
+
+		launch(g, nil, typedIn, typedOut, func(ctx context.Context) error {
+			return s(nested(ctx), typedIn, typedOut)
+		})
+
+		// passing the output on
+		launch[Out, interface{}](g, nil, typedOut, nil, func(ctx context.Context) error {
+			for {
+				value, ok, err := receive(ctx, typedOut)
+				if err != nil || !ok {
+					return err
+				}
+
+				if err := passItem[interface{}](ctx, out, value); err != nil {
+					return err
+				}
+			}
+		})
+
+		return g.Wait()
+	}
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"context"
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestChain(t *testing.T) {
+
+	var result []string
+
+	numbers := From(func(ctx context.Context, out chan int) error {
+		for i := 1; i <= 3; i++ {
+			if err := send(ctx, out, i); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	squares := Then(numbers, func(ctx context.Context, in chan int, out chan int) error {
+		for value := range in {
+			if err := send(ctx, out, value*value); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	texts := Then(squares, func(ctx context.Context, in chan int, out chan string) error {
+		for value := range in {
+			if err := send(ctx, out, strconv.Itoa(value)); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+
+	err := texts.Run(context.Background(), func(ctx context.Context, in chan string) error {
+		for value := range in {
+			result = append(result, value)
+		}
+		return nil
+	})
+
+	if err != nil {
+		t.Fatalf("unexpected error: %v", err)
+	}
+
+	if got := strings.Join(result, " "); got != "1 4 9" {
+		t.Errorf("results not match\nGot: %v\nExpected: %v", got, "1 4 9")
+	}
+}
+
+func TestUntypedMismatch(t *testing.T) {
+
+	double := func(ctx context.Context, in chan int, out chan int) error {
+		for value := range in {
+			if err := send(ctx, out, value*2); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	err := ExecutePipelineContext(context.Background(),
+		func(ctx context.Context, in, out chan interface{}) error {
+			return send(ctx, out, "two")
+		},
+		Untyped("double", double),
+		func(ctx context.Context, in, out chan interface{}) error {
+			for range in {
+			}
+			return nil
+		},
+	)
+
+	expected := "double: can`t convert source two (string) to int"
+	if err == nil || err.Error() != expected {
+		t.Errorf("wrong error\nGot: %v\nExpected: %v", err, expected)
+	}
+}